@@ -0,0 +1,27 @@
+package archive
+
+import (
+	"io"
+	"os"
+)
+
+// WritableFS is a filesystem archives can be extracted to
+type WritableFS interface {
+	// MkdirAll creates the folder path along with any missing parent folder
+	MkdirAll(path string, perm os.FileMode) error
+	// Create creates the file name, truncating it when it exists, and opens it for writing
+	Create(name string, perm os.FileMode) (io.WriteCloser, error)
+}
+
+// OSFS is the WritableFS of the operating system filesystem
+type OSFS struct{}
+
+// MkdirAll creates the folder path like os.MkdirAll
+func (OSFS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+// Create creates the file name with the perm permissions
+func (OSFS) Create(name string, perm os.FileMode) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+}
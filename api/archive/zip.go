@@ -3,13 +3,15 @@ package archive
 import (
 	"archive/zip"
 	"bytes"
+	"context"
 	"fmt"
-	"github.com/pkg/errors"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/pkg/errors"
 )
 
 // UnzipArchive will unzip an archive from bytes into the dest destination folder on disk
@@ -59,56 +61,157 @@ func extractFileFromArchive(file *zip.File, dest string) error {
 // UnzipFile will decompress a zip archive, moving all files and folders
 // within the zip file (parameter 1) to an output directory (parameter 2).
 func UnzipFile(src string, dest string) error {
+	return UnzipFileToFS(context.Background(), OSFS{}, src, dest, ExtractOptions{})
+}
+
+// ErrExtractLimitExceeded is returned when an archive holds more files or expands to more bytes than allowed
+var ErrExtractLimitExceeded = errors.New("archive exceeds the extraction limits")
+
+// ExtractLimits bounds what the extraction of an archive writes, protecting the disk against zip bombs.
+// Zero values mean unlimited.
+type ExtractLimits struct {
+	// MaxFiles is the maximum number of extracted files, folders excluded
+	MaxFiles int
+	// MaxUncompressedBytes is the maximum total size of the extracted files
+	MaxUncompressedBytes int64
+}
+
+// ExtractOptions configures the extraction of UnzipFileToFS, the zero value extracts the whole archive
+type ExtractOptions struct {
+	// Include selects the extracted files by name, folders are then only created when they contain an included file.
+	// A nil function extracts all files and folders.
+	Include func(name string) bool
+	// StripComponents is the number of leading folders removed from the file names, like tar --strip-components.
+	// Files located above the stripped depth are skipped.
+	StripComponents int
+	// Limits makes the extraction fail with ErrExtractLimitExceeded as soon as they are exceeded
+	Limits ExtractLimits
+}
+
+// UnzipFileToFS decompresses the zip archive src to the dest folder of the fsys filesystem.
+// It stops as soon as ctx is done, including in the middle of copying a file, and returns the context error.
+// Files already extracted when the extraction fails are left in place.
+func UnzipFileToFS(ctx context.Context, fsys WritableFS, src string, dest string, opts ExtractOptions) error {
+	return extractZip(ctx, fsys, src, dest, opts.Include, opts.StripComponents, opts.Limits)
+}
+
+func extractZip(ctx context.Context, fsys WritableFS, src string, dest string, include func(name string) bool, strip int, limits ExtractLimits) error {
 	r, err := zip.OpenReader(src)
 	if err != nil {
 		return err
 	}
 	defer r.Close()
 
+	files := 0
+	remainingBytes := limits.MaxUncompressedBytes
+
 	for _, f := range r.File {
-		p := filepath.Join(dest, f.Name)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		name := f.Name
+		if strip > 0 {
+			components := strings.SplitN(strings.TrimPrefix(name, "/"), "/", strip+1)
+			if len(components) <= strip || components[strip] == "" {
+				continue
+			}
+			name = components[strip]
+		}
+
+		p := filepath.Join(dest, name)
 
 		// Check for ZipSlip. More Info: http://bit.ly/2MsjAWE
 		if !strings.HasPrefix(p, filepath.Clean(dest)+string(os.PathSeparator)) {
 			return fmt.Errorf("%s: illegal file path", p)
 		}
 
+		if include != nil && (f.FileInfo().IsDir() || !include(name)) {
+			continue
+		}
+
 		if f.FileInfo().IsDir() {
 			// Make Folder
-			os.MkdirAll(p, os.ModePerm)
+			fsys.MkdirAll(p, os.ModePerm)
 			continue
 		}
 
-		err = unzipFile(f, p)
+		files++
+		if limits.MaxFiles > 0 && files > limits.MaxFiles {
+			return errors.Wrapf(ErrExtractLimitExceeded, "archive holds more than %d files", limits.MaxFiles)
+		}
+
+		maxBytes := int64(-1)
+		if limits.MaxUncompressedBytes > 0 {
+			// the declared size is checked first so that an oversized file isn't even started
+			if f.UncompressedSize64 > uint64(remainingBytes) {
+				return errors.Wrapf(ErrExtractLimitExceeded, "archive expands to more than %d bytes", limits.MaxUncompressedBytes)
+			}
+			maxBytes = remainingBytes
+		}
+
+		written, err := unzipFile(ctx, fsys, f, p, maxBytes)
 		if err != nil {
+			if errors.Is(err, ErrExtractLimitExceeded) {
+				return errors.Wrapf(err, "archive expands to more than %d bytes", limits.MaxUncompressedBytes)
+			}
 			return err
 		}
+		remainingBytes -= written
 	}
 
 	return nil
 }
 
-func unzipFile(f *zip.File, p string) error {
+// unzipFile extracts f to p and returns the number of bytes written,
+// failing with ErrExtractLimitExceeded when it's more than maxBytes, unless maxBytes is negative
+func unzipFile(ctx context.Context, fsys WritableFS, f *zip.File, p string, maxBytes int64) (int64, error) {
 	// Make File
-	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
-		return errors.Wrapf(err, "unzipFile: can't make a path %s", p)
+	if err := fsys.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return 0, errors.Wrapf(err, "unzipFile: can't make a path %s", p)
 	}
-	outFile, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+	outFile, err := fsys.Create(p, f.Mode())
 	if err != nil {
-		return errors.Wrapf(err, "unzipFile: can't create file %s", p)
+		return 0, errors.Wrapf(err, "unzipFile: can't create file %s", p)
 	}
 	defer outFile.Close()
 	rc, err := f.Open()
 	if err != nil {
-		return errors.Wrapf(err, "unzipFile: can't open zip file %s in the archive", f.Name)
+		return 0, errors.Wrapf(err, "unzipFile: can't open zip file %s in the archive", f.Name)
 	}
 	defer rc.Close()
 
-	_, err = io.Copy(outFile, rc)
+	var r io.Reader = &contextReader{ctx: ctx, r: rc}
+	if maxBytes >= 0 {
+		// reading one byte past the limit tells a file of exactly the remaining size from a larger one
+		r = io.LimitReader(r, maxBytes+1)
+	}
+
+	written, err := io.Copy(outFile, r)
 
 	if err != nil {
-		return errors.Wrapf(err, "unzipFile: can't copy an archived file content")
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return written, ctxErr
+		}
+		return written, errors.Wrapf(err, "unzipFile: can't copy an archived file content")
 	}
 
-	return nil
+	if maxBytes >= 0 && written > maxBytes {
+		return written, ErrExtractLimitExceeded
+	}
+
+	return written, nil
+}
+
+// contextReader fails reads once its context is done, so that copying a large file can be interrupted.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
 }
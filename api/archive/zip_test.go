@@ -1,11 +1,13 @@
 package archive
 
 import (
-	"github.com/stretchr/testify/assert"
+	"context"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestUnzipFile(t *testing.T) {
@@ -30,3 +32,78 @@ func TestUnzipFile(t *testing.T) {
 	assert.FileExists(t, filepath.Join(archiveDir, "0", "1", "2.txt"))
 
 }
+
+func TestUnzipFileToFS_include(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unzip-test-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = UnzipFileToFS(context.Background(), OSFS{}, "./testdata/sample_archive.zip", dir, ExtractOptions{
+		Include: func(name string) bool {
+			return filepath.Base(name) == "1.txt"
+		},
+	})
+
+	assert.NoError(t, err)
+	archiveDir := dir + "/sample_archive"
+	assert.FileExists(t, filepath.Join(archiveDir, "0", "1.txt"))
+	assert.NoFileExists(t, filepath.Join(archiveDir, "0.txt"))
+	assert.NoDirExists(t, filepath.Join(archiveDir, "0", "1"))
+}
+
+func TestUnzipFileToFS_stripComponents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unzip-test-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = UnzipFileToFS(context.Background(), OSFS{}, "./testdata/sample_archive.zip", dir, ExtractOptions{StripComponents: 1})
+
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dir, "0.txt"))
+	assert.FileExists(t, filepath.Join(dir, "0", "1.txt"))
+	assert.FileExists(t, filepath.Join(dir, "0", "1", "2.txt"))
+	assert.NoDirExists(t, filepath.Join(dir, "sample_archive"))
+}
+
+func TestUnzipFileToFS_cancelled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unzip-test-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = UnzipFileToFS(ctx, OSFS{}, "./testdata/sample_archive.zip", dir, ExtractOptions{})
+
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.NoFileExists(t, filepath.Join(dir, "sample_archive", "0.txt"))
+}
+
+func TestUnzipFileToFS_limits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  ExtractLimits
+		wantErr bool
+	}{
+		{name: "unlimited", limits: ExtractLimits{}},
+		{name: "within limits", limits: ExtractLimits{MaxFiles: 3, MaxUncompressedBytes: 1024}},
+		{name: "too many files", limits: ExtractLimits{MaxFiles: 2}, wantErr: true},
+		{name: "too many bytes", limits: ExtractLimits{MaxUncompressedBytes: 1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "unzip-test-")
+			assert.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			err = UnzipFileToFS(context.Background(), OSFS{}, "./testdata/sample_archive.zip", dir, ExtractOptions{Limits: tt.limits})
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrExtractLimitExceeded)
+				return
+			}
+			assert.NoError(t, err)
+			assert.FileExists(t, filepath.Join(dir, "sample_archive", "0", "1", "2.txt"))
+		})
+	}
+}
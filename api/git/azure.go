@@ -2,22 +2,35 @@ package git
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	"github.com/pkg/errors"
 	"github.com/portainer/portainer/api/archive"
+	"golang.org/x/sync/semaphore"
+	"golang.org/x/time/rate"
 )
 
 const (
 	azureDevOpsHost        = "dev.azure.com"
 	visualStudioHostSuffix = ".visualstudio.com"
+	// azureAPIVersion is the version of the Azure DevOps REST API the downloader uses
+	azureAPIVersion = "6.0"
 )
 
 func isAzureUrl(s string) bool {
@@ -26,245 +39,1981 @@ func isAzureUrl(s string) bool {
 }
 
 type azureOptions struct {
+	// host is the host name of the repository URL, e.g. dev.azure.com or ssh.dev.azure.com
+	host                              string
 	organisation, project, repository string
+	// projectOmitted tells that the url doesn't name the project, which has to be resolved from the repository
+	projectOmitted bool
+	// apiBaseUrl is the organisation-scoped base of the API URLs, e.g. https://organisation.visualstudio.com,
+	// empty when the organisation is part of the API paths of the downloader base url
+	apiBaseUrl string
 	// a user may pass credentials in a repository URL,
 	// for example https://<username>:<password>@<domain>/<path>
 	username, password string
 }
 
 type azureDownloader struct {
-	client  *http.Client
-	baseUrl string
+	client        *http.Client
+	baseUrl       string
+	lookupTimeout time.Duration
+	tokenSource   TokenSource
+	basicPAT      string
+	tracer        Tracer
+	logger        Logger
+	clock         Clock
+	extraHeaders  http.Header
+	userAgent     string
+	tokenMu       sync.Mutex
+	cachedToken   string
+	tokenExpiry   time.Time
+
+	maxConcurrentRequests int
+	semaphoresMu          sync.Mutex
+	semaphores            map[string]*semaphore.Weighted
+
+	maxArchiveBytes int64
+	tempDir         string
+
+	breakerThreshold int
+	breakerCooldown  time.Duration
+	breakersMu       sync.Mutex
+	breakers         map[string]*circuitBreaker
+
+	downloadRetries      int
+	downloadRetryBackoff time.Duration
+	retryPolicy          RetryPolicy
+	downloadBudget       *rate.Limiter
+
+	maxFiles             int
+	maxUncompressedBytes int64
+
+	maxRedirects         int
+	trustedRedirectHosts []string
+
+	rateLimitMu sync.Mutex
+	rateLimit   RateLimit
+
+	metricsMu sync.Mutex
+	metrics   map[string]OperationMetrics
+
+	projectsMu sync.Mutex
+	projects   map[string]cachedProject
+
+	emptyTreeOnNotFound bool
+	excludeHidden       bool
+	// allowedHosts restricts the repository hosts, any host is allowed when nil and none when empty
+	allowedHosts []string
+}
+
+func NewAzureDownloader(client *http.Client, opts ...AzureOption) *azureDownloader {
+	a := &azureDownloader{
+		client:  client,
+		baseUrl: "https://dev.azure.com",
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	a.installRedirectPolicy()
+
+	return a
+}
+
+func (a *azureDownloader) download(ctx context.Context, destination string, options cloneOptions) error {
+	return a.cleanupOnExtractLimit(destination, func() error {
+		return a.downloadToFS(ctx, archive.OSFS{}, destination, options)
+	})
+}
+
+// cleanupOnExtractLimit runs extract and removes what it extracted to destination when it exceeds the extraction limits,
+// so that the files of a zip bomb don't stay on disk
+func (a *azureDownloader) cleanupOnExtractLimit(destination string, extract func() error) error {
+	existing, err := listDirEntries(destination)
+	if err != nil {
+		return errors.WithMessage(err, "failed to inspect destination")
+	}
+
+	err = extract()
+	if errors.Is(err, archive.ErrExtractLimitExceeded) {
+		a.cleanupDestination(destination, existing)
+	}
+
+	return err
+}
+
+// downloadToFS extracts the repository into the destination folder of the fsys filesystem,
+// the archive itself being saved to a temp file of the operating system filesystem.
+// The files already extracted are left in fsys when the extraction limits are exceeded.
+func (a *azureDownloader) downloadToFS(ctx context.Context, fsys archive.WritableFS, destination string, options cloneOptions) error {
+	zipFilepath, err := a.downloadZipFromAzureDevOps(ctx, options)
+	if err != nil {
+		return errors.Wrap(err, "failed to download a zip file from Azure DevOps")
+	}
+	defer os.Remove(zipFilepath)
+
+	if scopePath := strings.Trim(options.scopePath, "/"); scopePath != "" {
+		// the archive of a folder holds the folder itself, its content is extracted as the destination root
+		fsys = rebasedFS{
+			WritableFS: fsys,
+			from:       filepath.Join(destination, path.Base(scopePath)),
+			to:         filepath.Clean(destination),
+		}
+	}
+
+	err = archive.UnzipFileToFS(ctx, fsys, zipFilepath, destination, archive.ExtractOptions{
+		Include: a.extractFilter(nil),
+		Limits:  a.extractLimits(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to unzip file")
+	}
+
+	return nil
+}
+
+// rebasedFS moves the files extracted under the from folder to the to folder
+type rebasedFS struct {
+	archive.WritableFS
+	from, to string
+}
+
+func (r rebasedFS) rebase(name string) string {
+	if name == r.from {
+		return r.to
+	}
+
+	if strings.HasPrefix(name, r.from+string(filepath.Separator)) {
+		return r.to + strings.TrimPrefix(name, r.from)
+	}
+
+	return name
+}
+
+func (r rebasedFS) MkdirAll(name string, perm os.FileMode) error {
+	return r.WritableFS.MkdirAll(r.rebase(name), perm)
+}
+
+func (r rebasedFS) Create(name string, perm os.FileMode) (io.WriteCloser, error) {
+	return r.WritableFS.Create(r.rebase(name), perm)
+}
+
+// downloadWithCleanup extracts the repository into destination like download, but leaves destination as it found it
+// when the download or the extraction fails, notably when ctx is cancelled by a shutdown: the files extracted so far
+// are removed, as well as destination itself when the call created it
+func (a *azureDownloader) downloadWithCleanup(ctx context.Context, destination string, options cloneOptions) error {
+	existing, err := listDirEntries(destination)
+	if err != nil {
+		return errors.WithMessage(err, "failed to inspect destination")
+	}
+
+	err = a.download(ctx, destination, options)
+	if err != nil {
+		a.cleanupDestination(destination, existing)
+		return err
+	}
+
+	return nil
+}
+
+// cleanupDestination removes what an interrupted extraction left in destination, see removeNewEntries
+func (a *azureDownloader) cleanupDestination(destination string, existing map[string]bool) {
+	if err := removeNewEntries(destination, existing); err != nil {
+		a.log().Warnf("failed to clean up the partially extracted repository in %s: %v", destination, err)
+	}
+}
+
+// listDirEntries returns the names of the entries of dir, or nil when dir doesn't exist
+func listDirEntries(dir string) (map[string]bool, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		names[entry.Name()] = true
+	}
+	return names, nil
+}
+
+// removeNewEntries removes the entries of dir missing from existing, or dir itself when existing is nil
+func removeNewEntries(dir string, existing map[string]bool) error {
+	if existing == nil {
+		return os.RemoveAll(dir)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if existing[entry.Name()] {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadResolved resolves the options ref to a commit id, then extracts the repository at that commit
+// into destination, so that the returned commit id is the one of the files even when the ref moves meanwhile
+func (a *azureDownloader) downloadResolved(ctx context.Context, destination string, options cloneOptions) (commitID string, err error) {
+	commitID, err = a.latestCommitID(ctx, fetchOptions{
+		repositoryUrl: options.repositoryUrl,
+		username:      options.username,
+		password:      options.password,
+		referenceName: options.referenceName,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	options.referenceName = commitID
+	if err := a.download(ctx, destination, options); err != nil {
+		return "", err
+	}
+
+	return commitID, nil
+}
+
+// downloadFiltered extracts only the repository files matching the options extensions into destination
+func (a *azureDownloader) downloadFiltered(ctx context.Context, destination string, options cloneOptions) error {
+	zipFilepath, err := a.downloadZipFromAzureDevOps(ctx, options)
+	if err != nil {
+		return errors.Wrap(err, "failed to download a zip file from Azure DevOps")
+	}
+	defer os.Remove(zipFilepath)
+
+	return a.cleanupOnExtractLimit(destination, func() error {
+		err := archive.UnzipFileToFS(ctx, archive.OSFS{}, zipFilepath, destination, archive.ExtractOptions{
+			Include: a.extractFilter(func(name string) bool {
+				return matchExtensions(name, options.extensions, options.caseSensitiveExtensions)
+			}),
+			Limits: a.extractLimits(),
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to unzip file")
+		}
+
+		return nil
+	})
+}
+
+// downloadZipAttempt downloads the repository archive to a temp file once, continuing the download interrupted
+// by the previous attempt described by partial, if any. When it fails, retry tells whether the failure is transient,
+// i.e. a network error or a server error, and partial is updated with the download to continue on the next attempt.
+func (a *azureDownloader) downloadZipAttempt(ctx context.Context, options cloneOptions, partial *partialDownload) (zipFilepath string, retry bool, err error) {
+	ctx, span := a.startSpan(ctx, "azure.download")
+	defer span.End()
+
+	req, err := a.newArchiveRequest(ctx, options)
+	if err != nil {
+		return "", false, err
+	}
+
+	// continue the previously interrupted download of the archive, as long as it didn't change
+	previous := *partial
+	*partial = partialDownload{}
+	resuming := previous.path != ""
+	if resuming {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", previous.size))
+		req.Header.Set("If-Range", previous.etag)
+	}
+
+	res, err := a.do(req)
+	if err != nil {
+		*partial = previous
+		return "", a.shouldRetry(ctx, nil, err), errors.WithMessage(redactURLError(err), "failed to make an HTTP request")
+	}
+	defer res.Body.Close()
+
+	if resuming && res.StatusCode != http.StatusPartialContent {
+		// the server ignored the range or the archive changed, start over
+		os.Remove(previous.path)
+		resuming = false
+	}
+
+	if resuming && !contentRangeStartsAt(res, previous.size) {
+		// appending a range other than the requested one would corrupt the archive, start over on the next attempt
+		os.Remove(previous.path)
+		return "", true, errors.Errorf("failed to resume the download: expected a range starting at byte %d, got %q", previous.size, res.Header.Get("Content-Range"))
+	}
+
+	if !resuming && res.StatusCode != http.StatusOK {
+		return "", a.shouldRetry(ctx, res, nil), errors.WithMessage(newAzureHTTPError(res), "failed to download zip")
+	}
+
+	var offset int64
+	if resuming {
+		offset = previous.size
+	}
+
+	if a.maxArchiveBytes > 0 && res.ContentLength >= 0 && offset+res.ContentLength > a.maxArchiveBytes {
+		if resuming {
+			os.Remove(previous.path)
+		}
+		return "", false, errors.Wrapf(ErrArchiveTooLarge, "archive of %d bytes exceeds the limit of %d bytes", offset+res.ContentLength, a.maxArchiveBytes)
+	}
+
+	var zipFile *os.File
+	if resuming {
+		zipFile, err = os.OpenFile(previous.path, os.O_WRONLY|os.O_APPEND, 0600)
+	} else {
+		zipFile, err = a.createTempFile("azure-git-repo-*.zip")
+	}
+	if err != nil {
+		return "", false, errors.WithMessage(err, "failed to create temp file")
+	}
+	defer zipFile.Close()
+
+	body := a.throttle(ctx, res.Body)
+	if a.maxArchiveBytes > 0 {
+		// reading one byte past the limit tells an archive of exactly the maximum size from a larger one
+		body = io.LimitReader(body, a.maxArchiveBytes-offset+1)
+	}
+
+	hash := sha256.New()
+	if options.expectedSHA256 != "" {
+		if resuming {
+			if err := hashFile(hash, previous.path); err != nil {
+				os.Remove(previous.path)
+				return "", false, err
+			}
+		}
+		body = io.TeeReader(body, hash)
+	}
+
+	written, err := io.Copy(zipFile, body)
+	span.SetAttribute("download.bytes", written)
+	if err != nil {
+		// a cancelled download isn't kept for resuming, the caller is likely shutting down
+		if etag := resumableETag(res, previous); etag != "" && ctx.Err() == nil {
+			*partial = partialDownload{path: zipFile.Name(), etag: etag, size: offset + written}
+		} else {
+			os.Remove(zipFile.Name())
+		}
+		return "", a.shouldRetry(ctx, res, err), errors.WithMessage(err, "failed to save HTTP response to a file")
+	}
+
+	if a.maxArchiveBytes > 0 && offset+written > a.maxArchiveBytes {
+		os.Remove(zipFile.Name())
+		return "", false, errors.Wrapf(ErrArchiveTooLarge, "archive exceeds the limit of %d bytes", a.maxArchiveBytes)
+	}
+
+	if options.expectedSHA256 != "" {
+		sum := hex.EncodeToString(hash.Sum(nil))
+		if !strings.EqualFold(sum, options.expectedSHA256) {
+			os.Remove(zipFile.Name())
+			return "", false, errors.Wrapf(ErrChecksumMismatch, "expected sha256 %s, got %s", options.expectedSHA256, sum)
+		}
+	}
+
+	return zipFile.Name(), false, nil
+}
+
+// hashFile writes the content of the file at path to hash
+func hashFile(hash io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "failed to open the partial archive")
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(hash, file); err != nil {
+		return errors.Wrap(err, "failed to hash the partial archive")
+	}
+
+	return nil
+}
+
+// downloadArchive streams the zip archive of the repository to w, without saving nor extracting it.
+// The download stops with ErrArchiveTooLarge once the configured maximum archive size is exceeded,
+// in which case w has received a truncated archive.
+func (a *azureDownloader) downloadArchive(ctx context.Context, w io.Writer, options cloneOptions) error {
+	ctx, span := a.startSpan(ctx, "azure.download")
+	defer span.End()
+
+	req, err := a.newArchiveRequest(ctx, options)
+	if err != nil {
+		return err
+	}
+
+	res, err := a.do(req)
+	if err != nil {
+		return errors.WithMessage(redactURLError(err), "failed to make an HTTP request")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.WithMessage(newAzureHTTPError(res), "failed to download zip")
+	}
+
+	if a.maxArchiveBytes > 0 && res.ContentLength > a.maxArchiveBytes {
+		return errors.Wrapf(ErrArchiveTooLarge, "archive of %d bytes exceeds the limit of %d bytes", res.ContentLength, a.maxArchiveBytes)
+	}
+
+	body := a.throttle(ctx, res.Body)
+	if a.maxArchiveBytes > 0 {
+		body = io.LimitReader(body, a.maxArchiveBytes+1)
+	}
+
+	written, err := io.Copy(w, body)
+	span.SetAttribute("download.bytes", written)
+	if err != nil {
+		return errors.WithMessage(err, "failed to stream the archive")
+	}
+
+	if a.maxArchiveBytes > 0 && written > a.maxArchiveBytes {
+		return errors.Wrapf(ErrArchiveTooLarge, "archive exceeds the limit of %d bytes", a.maxArchiveBytes)
+	}
+
+	return nil
+}
+
+// newArchiveRequest builds the authorized request downloading the zip archive of the repository
+func (a *azureDownloader) newArchiveRequest(ctx context.Context, options cloneOptions) (*http.Request, error) {
+	// the inputs are validated before sending any request
+	scopePath, err := validateScopePath(options.scopePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateReferenceName(options.referenceName); err != nil {
+		return nil, err
+	}
+
+	config, err := a.parseRepositoryUrl(ctx, options.repositoryUrl, options.username, options.password)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to parse url")
+	}
+	referenceName, err := a.referenceNameOrDefault(ctx, fetchOptions{
+		repositoryUrl: options.repositoryUrl,
+		username:      options.username,
+		password:      options.password,
+		referenceName: options.referenceName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the default branch name comes from the server and ends up in the url as well
+	if err := validateReferenceName(referenceName); err != nil {
+		return nil, err
+	}
+
+	downloadUrl, err := a.buildFolderDownloadUrl(config, referenceName, scopePath, options.recursionLevel)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to build download url")
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", downloadUrl, nil)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to create a new HTTP request")
+	}
+	// the archive is already compressed
+	req.Header.Set("Accept-Encoding", "identity")
+
+	if err := a.authorize(ctx, req, options.username, options.password, config); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// authorize sets the request credentials. A token obtained from the configured
+// token source takes precedence over a configured personal access token, which takes precedence
+// over static credentials. Explicitly passed credentials take precedence over the ones embedded
+// in the repository URL.
+func (a *azureDownloader) authorize(ctx context.Context, req *http.Request, username, password string, config *azureOptions) error {
+	if a.tokenSource != nil {
+		token, err := a.token(ctx)
+		if err != nil {
+			return errors.WithMessage(err, "failed to obtain an access token")
+		}
+
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+
+	if a.basicPAT != "" {
+		req.SetBasicAuth("", a.basicPAT)
+		return nil
+	}
+
+	if username != "" || password != "" {
+		req.SetBasicAuth(username, password)
+	} else if config.username != "" || config.password != "" {
+		req.SetBasicAuth(config.username, config.password)
+	}
+
+	return nil
+}
+
+func (a *azureDownloader) latestCommitID(ctx context.Context, options fetchOptions) (string, error) {
+	// the items of an annotated tag resolve to the tag object and short ref names aren't understood
+	// by the items API, so tags and short names are resolved and dereferenced from the refs instead
+	referenceName := options.referenceName
+	isShortName := referenceName != "" && !strings.HasPrefix(referenceName, "refs/") && !commitIdPattern.MatchString(referenceName)
+	if strings.HasPrefix(referenceName, tagPrefix) || isShortName {
+		refs, err := a.listRefs(ctx, options)
+		if err != nil {
+			return "", err
+		}
+
+		names := make([]string, len(refs))
+		for i, ref := range refs {
+			names[i] = ref.Name
+		}
+
+		fullRefName, err := resolveReferenceName(names, referenceName)
+		if err != nil {
+			return "", err
+		}
+
+		for _, ref := range refs {
+			if ref.Name == fullRefName {
+				return ref.commitId(), nil
+			}
+		}
+	}
+
+	rootItem, err := a.getRootItem(ctx, options)
+	if err != nil {
+		return "", err
+	}
+
+	return rootItem.CommitId, nil
+}
+
+// getFile returns the content of a single repository file without downloading the whole archive
+func (a *azureDownloader) getFile(ctx context.Context, options fetchOptions, filePath string) ([]byte, error) {
+	ctx, cancel := a.lookupContext(ctx)
+	defer cancel()
+
+	config, err := a.parseRepositoryUrl(ctx, options.repositoryUrl, options.username, options.password)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to parse url")
+	}
+
+	options.referenceName, err = a.referenceNameOrDefault(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	fileUrl, err := a.buildFileUrl(config, options.referenceName, filePath)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to build azure file url")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", fileUrl, nil)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to create a new HTTP request")
+	}
+
+	if err := a.authorize(ctx, req, options.username, options.password, config); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/octet-stream")
+
+	resp, err := a.do(req)
+	if err != nil {
+		return nil, errors.WithMessage(redactURLError(err), "failed to make an HTTP request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.WithMessagef(newAzureHTTPError(resp), "file %q not found in the repository", filePath)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.WithMessagef(newAzureHTTPError(resp), "failed to get file %q", filePath)
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to read HTTP response")
+	}
+
+	return content, nil
+}
+
+// defaultGetFilesConcurrency bounds the simultaneous requests of getFiles and listTreesForRefs
+// when no concurrency limit is configured
+const defaultGetFilesConcurrency = 4
+
+// forEachConcurrently calls fn with each of the items, with at most the configured number of concurrent requests,
+// defaultGetFilesConcurrency by default, and waits for the calls to return. Once ctx is done, the remaining items
+// are skipped and the context error is returned.
+func (a *azureDownloader) forEachConcurrently(ctx context.Context, items []string, fn func(item string)) error {
+	concurrency := defaultGetFilesConcurrency
+	if a.maxConcurrentRequests > 0 {
+		concurrency = a.maxConcurrentRequests
+	}
+
+	var (
+		wg    sync.WaitGroup
+		slots = make(chan struct{}, concurrency)
+	)
+	defer wg.Wait()
+
+	for _, item := range items {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		// both cases may be ready at once, don't start an item after the context is done
+		if err := ctx.Err(); err != nil {
+			<-slots
+			return err
+		}
+
+		wg.Add(1)
+		go func(item string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			fn(item)
+		}(item)
+	}
+
+	return nil
+}
+
+// getFiles returns the content of several repository files, fetched in parallel. The files that
+// couldn't be fetched are missing from the returned contents and reported together by the error.
+func (a *azureDownloader) getFiles(ctx context.Context, options fetchOptions, filePaths []string) (map[string][]byte, error) {
+	var err error
+	options.referenceName, err = a.referenceNameOrDefault(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu       sync.Mutex
+		contents = make(map[string][]byte, len(filePaths))
+		failures []string
+	)
+
+	err = a.forEachConcurrently(ctx, filePaths, func(filePath string) {
+		content, err := a.getFile(ctx, options, filePath)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err != nil {
+			failures = append(failures, err.Error())
+			return
+		}
+		contents[filePath] = content
+	})
+	if err != nil {
+		return contents, err
+	}
+
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return contents, errors.Errorf("failed to get %d of %d files: %s", len(failures), len(filePaths), strings.Join(failures, "; "))
+	}
+
+	return contents, nil
+}
+
+// defaultBranch returns the short name of the repository default branch,
+// or an empty string when the repository has no branch yet
+func (a *azureDownloader) defaultBranch(ctx context.Context, options fetchOptions) (string, error) {
+	config, err := a.parseRepositoryUrl(ctx, options.repositoryUrl, options.username, options.password)
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to parse url")
+	}
+
+	repositoryUrl, err := a.buildRepositoryUrl(config)
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to build azure repository url")
+	}
+
+	ctx, cancel := a.lookupContext(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", repositoryUrl, nil)
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to create a new HTTP request")
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	if err := a.authorize(ctx, req, options.username, options.password, config); err != nil {
+		return "", err
+	}
+
+	resp, err := a.do(req)
+	if err != nil {
+		return "", errors.WithMessage(redactURLError(err), "failed to make an HTTP request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.WithMessage(newAzureHTTPError(resp), "failed to get repository")
+	}
+
+	var repository struct {
+		DefaultBranch string `json:"defaultBranch"`
+	}
+
+	if err := decodeJSON(resp, &repository); err != nil {
+		return "", errors.Wrap(err, "could not parse Azure repository response")
+	}
+
+	return formatReferenceName(repository.DefaultBranch), nil
+}
+
+// referenceNameOrDefault returns the options reference name,
+// or the full name of the repository default branch when it's empty
+func (a *azureDownloader) referenceNameOrDefault(ctx context.Context, options fetchOptions) (string, error) {
+	if options.referenceName != "" {
+		return options.referenceName, nil
+	}
+
+	branch, err := a.defaultBranch(ctx, options)
+	if err != nil || branch == "" {
+		return "", err
+	}
+
+	return branchPrefix + branch, nil
+}
+
+type azureItem struct {
+	ObjectId      string `json:"objectId"`
+	GitObjectType string `json:"gitObjectType"`
+	CommitId      string `json:"commitId"`
+	Path          string `json:"path"`
+	IsFolder      bool   `json:"isFolder"`
+	Size          int64  `json:"size"`
+}
+
+func (a *azureDownloader) getRootItem(ctx context.Context, options fetchOptions) (*azureItem, error) {
+	ctx, span := a.startSpan(ctx, "azure.getRootItem")
+	defer span.End()
+
+	return a.getItem(ctx, options, "/")
+}
+
+// getItem returns the item at scopePath, which is the folder or file itself
+// as Azure lists the item without its children
+func (a *azureDownloader) getItem(ctx context.Context, options fetchOptions, scopePath string) (*azureItem, error) {
+	ctx, cancel := a.lookupContext(ctx)
+	defer cancel()
+
+	config, err := a.parseRepositoryUrl(ctx, options.repositoryUrl, options.username, options.password)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to parse url")
+	}
+
+	options.referenceName, err = a.referenceNameOrDefault(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	itemUrl, err := a.buildItemUrl(config, options.referenceName, scopePath)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to build azure item url")
+	}
+
+	item, err := a.requestItem(ctx, itemUrl, options, config, scopePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if item.CommitId == "" {
+		return nil, errors.Errorf("failed to get latest commitID in the repository")
+	}
+
+	return item, nil
+}
+
+// ItemMeta describes a file or folder of a repository
+type ItemMeta struct {
+	Path     string
+	ObjectID string
+	// GitObjectType is blob for files and tree for folders
+	GitObjectType string
+	IsFolder      bool
+	// Size is the size in bytes of a file, zero for folders
+	Size int64
+}
+
+// getItemMetadata returns the metadata of the file or folder at itemPath without downloading its content
+func (a *azureDownloader) getItemMetadata(ctx context.Context, options fetchOptions, itemPath string) (*ItemMeta, error) {
+	ctx, cancel := a.lookupContext(ctx)
+	defer cancel()
+
+	config, err := a.parseRepositoryUrl(ctx, options.repositoryUrl, options.username, options.password)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to parse url")
+	}
+
+	options.referenceName, err = a.referenceNameOrDefault(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	metadataUrl, err := a.buildItemMetadataUrl(config, options.referenceName, itemPath)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to build azure item metadata url")
+	}
+
+	item, err := a.requestItem(ctx, metadataUrl, options, config, itemPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ItemMeta{
+		Path:          item.Path,
+		ObjectID:      item.ObjectId,
+		GitObjectType: item.GitObjectType,
+		IsFolder:      item.IsFolder,
+		Size:          item.Size,
+	}, nil
+}
+
+// pathExists returns whether a file or folder exists at itemPath at the options ref, without downloading its content.
+// Only a path missing from the repository returns false, a missing repository or ref is an error.
+func (a *azureDownloader) pathExists(ctx context.Context, options fetchOptions, itemPath string) (bool, error) {
+	_, err := a.getItemMetadata(ctx, options, itemPath)
+	if isItemNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// requestItem returns the first item listed at itemUrl
+func (a *azureDownloader) requestItem(ctx context.Context, itemUrl string, options fetchOptions, config *azureOptions, itemPath string) (*azureItem, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", itemUrl, nil)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to create a new HTTP request")
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	if err := a.authorize(ctx, req, options.username, options.password, config); err != nil {
+		return nil, err
+	}
+
+	resp, err := a.do(req)
+	if err != nil {
+		return nil, errors.WithMessage(redactURLError(err), "failed to make an HTTP request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.WithMessagef(newAzureHTTPError(resp), "repository item %q could not be found", itemPath)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.WithMessagef(newAzureHTTPError(resp), "failed to get repository item %q", itemPath)
+	}
+
+	var items struct {
+		Value []azureItem
+	}
+
+	if err := decodeJSON(resp, &items); err != nil {
+		return nil, errors.Wrap(err, "could not parse Azure items response")
+	}
+
+	if len(items.Value) == 0 {
+		return nil, errors.Errorf("repository item %q could not be found", itemPath)
+	}
+
+	return &items.Value[0], nil
+}
+
+// checkConnection verifies that the repository exists and is reachable with the given credentials
+// by requesting a single ref
+func (a *azureDownloader) checkConnection(ctx context.Context, options fetchOptions) error {
+	config, err := a.parseRepositoryUrl(ctx, options.repositoryUrl, options.username, options.password)
+	if err != nil {
+		return errors.WithMessage(err, "failed to parse url")
+	}
+
+	refsUrl, err := a.buildRefsUrl(config)
+	if err != nil {
+		return errors.WithMessage(err, "failed to build azure refs url")
+	}
+
+	u, err := url.Parse(refsUrl)
+	if err != nil {
+		return errors.Wrap(redactURLError(err), "failed to parse refs url")
+	}
+	q := u.Query()
+	q.Set("$top", "1")
+	u.RawQuery = q.Encode()
+
+	ctx, cancel := a.lookupContext(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return errors.WithMessage(err, "failed to create a new HTTP request")
+	}
+
+	if err := a.authorize(ctx, req, options.username, options.password, config); err != nil {
+		return err
+	}
+
+	resp, err := a.do(req)
+	if err != nil {
+		return errors.WithMessage(redactURLError(err), "failed to make an HTTP request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.WithMessage(newAzureHTTPError(resp), "failed to check the repository connection")
+	}
+
+	return nil
+}
+
+type azureRef struct {
+	Name     string `json:"name"`
+	ObjectId string `json:"objectId"`
+	// PeeledObjectId is the id of the commit an annotated tag points to
+	PeeledObjectId string `json:"peeledObjectId"`
+}
+
+// commitId returns the id of the commit the ref points to, dereferencing annotated tags
+func (r azureRef) commitId() string {
+	if r.PeeledObjectId != "" {
+		return r.PeeledObjectId
+	}
+
+	return r.ObjectId
+}
+
+// listRemote returns the names of the repository refs of the options ref types, sorted
+func (a *azureDownloader) listRemote(ctx context.Context, options fetchOptions) ([]string, error) {
+	refs, err := a.listRefs(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		if options.refTypes.match(ref.Name) {
+			names = append(names, ref.Name)
+		}
+	}
+
+	return names, nil
+}
+
+// listRefs returns the repository refs sorted by name, with annotated tags peeled
+func (a *azureDownloader) listRefs(ctx context.Context, options fetchOptions) ([]azureRef, error) {
+	ctx, span := a.startSpan(ctx, "azure.listRemote")
+	defer span.End()
+
+	config, err := a.parseRepositoryUrl(ctx, options.repositoryUrl, options.username, options.password)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to parse url")
+	}
+
+	refsUrl, err := a.buildRefsUrl(config)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to build azure refs url")
+	}
+
+	ctx, cancel := a.lookupContext(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", refsUrl, nil)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to create a new HTTP request")
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	if err := a.authorize(ctx, req, options.username, options.password, config); err != nil {
+		return nil, err
+	}
+
+	resp, err := a.do(req)
+	if err != nil {
+		return nil, errors.WithMessage(redactURLError(err), "failed to make an HTTP request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.WithMessage(newAzureHTTPError(resp), "failed to get repository refs")
+	}
+
+	var refs struct {
+		Value []azureRef
+	}
+
+	if err := decodeJSON(resp, &refs); err != nil {
+		return nil, errors.Wrap(err, "could not parse Azure refs response")
+	}
+
+	// Azure doesn't guarantee the order of the refs, sort them for stable results
+	sort.Slice(refs.Value, func(i, j int) bool {
+		return refs.Value[i].Name < refs.Value[j].Name
+	})
+
+	return refs.Value, nil
+}
+
+// resolveRef resolves a short or full ref name to its full name and the id of the commit it currently
+// points to, annotated tags being dereferenced to their commit. A commit id, possibly abbreviated,
+// resolves to the full commit id for both values. An empty ref name resolves the default branch.
+func (a *azureDownloader) resolveRef(ctx context.Context, options fetchOptions) (fullRefName, commitID string, err error) {
+	referenceName, err := a.referenceNameOrDefault(ctx, options)
+	if err != nil {
+		return "", "", err
+	}
+
+	refs, err := a.listRefs(ctx, options)
+	if err != nil {
+		return "", "", err
+	}
+
+	names := make([]string, len(refs))
+	for i, ref := range refs {
+		names[i] = ref.Name
+	}
+
+	fullRefName, err = resolveReferenceName(names, referenceName)
+	if err == nil {
+		for _, ref := range refs {
+			if ref.Name == fullRefName {
+				return ref.Name, ref.commitId(), nil
+			}
+		}
+	}
+
+	if !commitIdPattern.MatchString(referenceName) {
+		return "", "", err
+	}
+
+	commitID, err = a.getCommitId(ctx, options, referenceName)
+	if err != nil {
+		return "", "", err
+	}
+
+	return commitID, commitID, nil
+}
+
+// commitIdPattern matches full and abbreviated commit ids
+var commitIdPattern = regexp.MustCompile(`^[0-9a-fA-F]{7,40}$`)
+
+// getCommitId returns the full id of the commit identified by commitId
+func (a *azureDownloader) getCommitId(ctx context.Context, options fetchOptions, commitId string) (string, error) {
+	config, err := a.parseRepositoryUrl(ctx, options.repositoryUrl, options.username, options.password)
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to parse url")
+	}
+
+	commitUrl, err := a.buildCommitUrl(config, commitId)
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to build azure commit url")
+	}
+
+	ctx, cancel := a.lookupContext(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", commitUrl, nil)
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to create a new HTTP request")
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	if err := a.authorize(ctx, req, options.username, options.password, config); err != nil {
+		return "", err
+	}
+
+	resp, err := a.do(req)
+	if err != nil {
+		return "", errors.WithMessage(redactURLError(err), "failed to make an HTTP request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", errors.Wrapf(ErrRefNotFound, "commit %q", commitId)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.WithMessage(newAzureHTTPError(resp), "failed to get commit")
+	}
+
+	var commit struct {
+		CommitId string `json:"commitId"`
+	}
+
+	if err := decodeJSON(resp, &commit); err != nil {
+		return "", errors.Wrap(err, "could not parse Azure commit response")
+	}
+
+	if commit.CommitId == "" {
+		return "", errors.Wrapf(ErrRefNotFound, "commit %q", commitId)
+	}
+
+	return commit.CommitId, nil
+}
+
+// resolveReferenceName returns the full name of the ref matching name. Full ref names
+// are matched exactly, short names are resolved to a branch first, then to a tag.
+func resolveReferenceName(refs []string, name string) (string, error) {
+	candidates := []string{name}
+	if !strings.HasPrefix(name, "refs/") {
+		candidates = append(candidates, branchPrefix+name, tagPrefix+name)
+	}
+
+	for _, candidate := range candidates {
+		for _, ref := range refs {
+			if ref == candidate {
+				return ref, nil
+			}
+		}
+	}
+
+	return "", errors.Wrapf(ErrRefNotFound, "ref %q", name)
+}
+
+// TreeEntry represents a file of a repository tree
+type TreeEntry struct {
+	RelativePath  string `json:"relativePath"`
+	ObjectID      string `json:"objectId"`
+	Size          int64  `json:"size"`
+	GitObjectType string `json:"gitObjectType"`
+}
+
+// listTree returns the sorted relative paths of the repository files matching the options extensions
+func (a *azureDownloader) listTree(ctx context.Context, options fetchOptions) ([]string, error) {
+	entries, err := a.listTreeDetailed(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		paths = append(paths, entry.RelativePath)
+	}
+
+	return paths, nil
+}
+
+// listTreeDetailed returns the repository files matching the options extensions
+// along with their object ids and sizes, sorted by path
+func (a *azureDownloader) listTreeDetailed(ctx context.Context, options fetchOptions) ([]TreeEntry, error) {
+	var entries []TreeEntry
+	err := a.walkTree(ctx, options, func(entry TreeEntry) error {
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if entries == nil {
+		entries = []TreeEntry{}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].RelativePath < entries[j].RelativePath
+	})
+
+	return entries, nil
+}
+
+// listTreeFunc calls fn with the relative path of each repository file matching the options filters,
+// as the tree response is decoded, hence in the server order. Listing stops at the first error returned by fn,
+// StopIteration stops it without error.
+func (a *azureDownloader) listTreeFunc(ctx context.Context, options fetchOptions, fn func(path string) error) error {
+	err := a.walkTree(ctx, options, func(entry TreeEntry) error {
+		return fn(entry.RelativePath)
+	})
+	if errors.Is(err, StopIteration) {
+		return nil
+	}
+
+	return err
+}
+
+// listTreesForRefs lists the trees of several refs concurrently, within the configured concurrency limit,
+// returning the sorted paths of the files matching the options filters per ref. Refs failing to be listed
+// are missing from the results, which are returned along with an error describing all the failures.
+func (a *azureDownloader) listTreesForRefs(ctx context.Context, options fetchOptions, refs []string) (map[string][]string, error) {
+	var (
+		mu       sync.Mutex
+		trees    = make(map[string][]string, len(refs))
+		failures []string
+	)
+
+	err := a.forEachConcurrently(ctx, refs, func(ref string) {
+		refOptions := options
+		refOptions.referenceName = ref
+		paths, err := a.listTree(ctx, refOptions)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", ref, err))
+			return
+		}
+		trees[ref] = paths
+	})
+	if err != nil {
+		return trees, err
+	}
+
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return trees, errors.Errorf("failed to list the trees of %d of %d refs: %s", len(failures), len(refs), strings.Join(failures, "; "))
+	}
+
+	return trees, nil
+}
+
+// stateFingerprint returns a hash of the commit the options ref points to and of the object ids of the
+// repository files matching the options filters, which changes whenever the ref moves or a listed file changes
+func (a *azureDownloader) stateFingerprint(ctx context.Context, options fetchOptions) (string, error) {
+	commitID, err := a.latestCommitID(ctx, options)
+	if err != nil {
+		return "", err
+	}
+
+	// list the tree of the resolved commit rather than of the ref, which may have moved meanwhile
+	options.referenceName = commitID
+	entries, err := a.listTreeDetailed(ctx, options)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "commit %s\n", commitID)
+	for _, entry := range entries {
+		fmt.Fprintf(hash, "%s %s\n", entry.ObjectID, entry.RelativePath)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
-func NewAzureDownloader(client *http.Client) *azureDownloader {
-	return &azureDownloader{
-		client:  client,
-		baseUrl: "https://dev.azure.com",
+// walkTree calls fn with each repository file matching the options filters, as the tree response is decoded
+func (a *azureDownloader) walkTree(ctx context.Context, options fetchOptions, fn func(entry TreeEntry) error) error {
+	ctx, span := a.startSpan(ctx, "azure.listTree")
+	defer span.End()
+
+	// an empty tree may not be found, which can only be told from a missing repository or ref
+	// when the ref was listed
+	refListed := false
+
+	// commits aren't refs, their existence is checked by the item request
+	if options.referenceName != "" && !commitIdPattern.MatchString(options.referenceName) {
+		// the ref to resolve may be of any type
+		options.refTypes = refTypesAll
+		refs, err := a.listRemote(ctx, options)
+		if err != nil {
+			return err
+		}
+
+		options.referenceName, err = resolveReferenceName(refs, options.referenceName)
+		if err != nil {
+			return err
+		}
+		refListed = true
 	}
-}
 
-func (a *azureDownloader) download(ctx context.Context, destination string, options cloneOptions) error {
-	zipFilepath, err := a.downloadZipFromAzureDevOps(ctx, options)
-	if err != nil {
-		return errors.Wrap(err, "failed to download a zip file from Azure DevOps")
+	if options.pathPrefix != "" {
+		return a.walkItems(ctx, options, fn)
 	}
-	defer os.Remove(zipFilepath)
 
-	err = archive.UnzipFile(zipFilepath, destination)
+	scopePath := strings.Trim(options.scopePath, "/")
+	emptyOnNotFound := a.emptyTreeOnNotFound && refListed && scopePath == ""
+
+	rootItem, err := a.getItem(ctx, options, scopePath)
 	if err != nil {
-		return errors.Wrap(err, "failed to unzip file")
+		if emptyOnNotFound && (isItemNotFound(err) || errors.Is(err, ErrIncorrectRepositoryURL)) {
+			return nil
+		}
+		return err
 	}
 
-	return nil
-}
+	if !rootItem.IsFolder {
+		return errors.Errorf("%q is not a folder of the repository", options.scopePath)
+	}
 
-func (a *azureDownloader) downloadZipFromAzureDevOps(ctx context.Context, options cloneOptions) (string, error) {
-	config, err := parseUrl(options.repositoryUrl)
+	config, err := a.parseRepositoryUrl(ctx, options.repositoryUrl, options.username, options.password)
 	if err != nil {
-		return "", errors.WithMessage(err, "failed to parse url")
+		return errors.WithMessage(err, "failed to parse url")
 	}
-	downloadUrl, err := a.buildDownloadUrl(config, options.referenceName)
+
+	treeUrl, err := a.buildTreeUrl(config, rootItem.ObjectId, options.recursionLevel)
 	if err != nil {
-		return "", errors.WithMessage(err, "failed to build download url")
+		return errors.WithMessage(err, "failed to build azure tree url")
 	}
-	zipFile, err := ioutil.TempFile("", "azure-git-repo-*.zip")
+
+	ctx, cancel := a.lookupContext(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", treeUrl, nil)
 	if err != nil {
-		return "", errors.WithMessage(err, "failed to create temp file")
+		return errors.WithMessage(err, "failed to create a new HTTP request")
 	}
-	defer zipFile.Close()
+	req.Header.Set("Accept-Encoding", "gzip")
 
-	req, err := http.NewRequestWithContext(ctx, "GET", downloadUrl, nil)
-	if options.username != "" || options.password != "" {
-		req.SetBasicAuth(options.username, options.password)
-	} else if config.username != "" || config.password != "" {
-		req.SetBasicAuth(config.username, config.password)
+	if err := a.authorize(ctx, req, options.username, options.password, config); err != nil {
+		return err
 	}
 
+	resp, err := a.do(req)
 	if err != nil {
-		return "", errors.WithMessage(err, "failed to create a new HTTP request")
+		return errors.WithMessage(redactURLError(err), "failed to make an HTTP request")
 	}
+	defer resp.Body.Close()
 
-	res, err := a.client.Do(req)
-	if err != nil {
-		return "", errors.WithMessage(err, "failed to make an HTTP request")
+	if resp.StatusCode == http.StatusNotFound && emptyOnNotFound {
+		return nil
 	}
-	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to download zip with a status \"%v\"", res.Status)
+	if resp.StatusCode != http.StatusOK {
+		return errors.WithMessage(newAzureHTTPError(resp), "failed to get repository tree")
 	}
 
-	_, err = io.Copy(zipFile, res.Body)
+	body, err := jsonResponseBody(resp)
 	if err != nil {
-		return "", errors.WithMessage(err, "failed to save HTTP response to a file")
+		return err
 	}
-	return zipFile.Name(), nil
+	defer body.Close()
+
+	return decodeTreeEntries(body, func(entry TreeEntry) error {
+		if entry.GitObjectType != "blob" {
+			return nil
+		}
+
+		// subtree entries are relative to the subtree, make them relative to the repository root
+		entry.RelativePath = path.Join(scopePath, entry.RelativePath)
+
+		if a.includePath(entry.RelativePath) &&
+			matchExtensions(entry.RelativePath, options.extensions, options.caseSensitiveExtensions) &&
+			matchPatterns(entry.RelativePath, options.patterns) {
+			return fn(entry)
+		}
+
+		return nil
+	})
 }
 
-func (a *azureDownloader) latestCommitID(ctx context.Context, options fetchOptions) (string, error) {
-	config, err := parseUrl(options.repositoryUrl)
+// walkItems calls fn with each file under the options path prefix matching the options filters.
+// Unlike the subtree listing of scopePath, the prefix is filtered by Azure DevOps, which lists the items
+// of the folder without resolving its tree first.
+func (a *azureDownloader) walkItems(ctx context.Context, options fetchOptions, fn func(entry TreeEntry) error) error {
+	config, err := a.parseRepositoryUrl(ctx, options.repositoryUrl, options.username, options.password)
 	if err != nil {
-		return "", errors.WithMessage(err, "failed to parse url")
+		return errors.WithMessage(err, "failed to parse url")
 	}
 
-	rootItemUrl, err := a.buildRootItemUrl(config, options.referenceName)
+	itemsUrl, err := a.buildItemsUrl(config, options.referenceName, options.pathPrefix)
 	if err != nil {
-		return "", errors.WithMessage(err, "failed to build azure root item url")
+		return errors.WithMessage(err, "failed to build azure items url")
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", rootItemUrl, nil)
-	if options.username != "" || options.password != "" {
-		req.SetBasicAuth(options.username, options.password)
-	} else if config.username != "" || config.password != "" {
-		req.SetBasicAuth(config.username, config.password)
-	}
+	ctx, cancel := a.lookupContext(ctx)
+	defer cancel()
 
+	req, err := http.NewRequestWithContext(ctx, "GET", itemsUrl, nil)
 	if err != nil {
-		return "", errors.WithMessage(err, "failed to create a new HTTP request")
+		return errors.WithMessage(err, "failed to create a new HTTP request")
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	if err := a.authorize(ctx, req, options.username, options.password, config); err != nil {
+		return err
 	}
 
-	resp, err := a.client.Do(req)
+	resp, err := a.do(req)
 	if err != nil {
-		return "", errors.WithMessage(err, "failed to make an HTTP request")
+		return errors.WithMessage(redactURLError(err), "failed to make an HTTP request")
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to get repository root item with a status \"%v\"", resp.Status)
+		return errors.WithMessagef(newAzureHTTPError(resp), "failed to list repository items under %q", options.pathPrefix)
 	}
 
-	var items struct {
-		Value []struct {
-			CommitId string `json:"commitId"`
+	body, err := jsonResponseBody(resp)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return decodeItems(body, func(item azureItem) error {
+		if item.GitObjectType != "blob" {
+			return nil
+		}
+
+		entry := TreeEntry{
+			RelativePath:  strings.TrimPrefix(item.Path, "/"),
+			ObjectID:      item.ObjectId,
+			GitObjectType: item.GitObjectType,
+		}
+
+		if a.includePath(entry.RelativePath) &&
+			matchExtensions(entry.RelativePath, options.extensions, options.caseSensitiveExtensions) &&
+			matchPatterns(entry.RelativePath, options.patterns) {
+			return fn(entry)
+		}
+
+		return nil
+	})
+}
+
+// decodeTreeEntries decodes the entries of an Azure tree response one at a time,
+// so that fn can process them without the whole tree being held in memory
+func decodeTreeEntries(r io.Reader, fn func(entry TreeEntry) error) error {
+	return decodeArrayField(r, "treeEntries", "tree", func(dec *json.Decoder) error {
+		var entry TreeEntry
+		if err := dec.Decode(&entry); err != nil {
+			return errors.Wrap(err, "could not parse Azure tree response")
+		}
+
+		return fn(entry)
+	})
+}
+
+// decodeItems decodes the items of an Azure items list response one at a time
+func decodeItems(r io.Reader, fn func(item azureItem) error) error {
+	return decodeArrayField(r, "value", "items", func(dec *json.Decoder) error {
+		var item azureItem
+		if err := dec.Decode(&item); err != nil {
+			return errors.Wrap(err, "could not parse Azure items response")
+		}
+
+		return fn(item)
+	})
+}
+
+// decodeArrayField calls decodeElement for each element of the field array of a JSON object,
+// skipping the other fields of the object
+func decodeArrayField(r io.Reader, field, responseType string, decodeElement func(dec *json.Decoder) error) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{', responseType); err != nil {
+		return err
+	}
+
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return errors.Wrapf(err, "could not parse Azure %s response", responseType)
+		}
+
+		if key != field {
+			var skipped json.RawMessage
+			if err := dec.Decode(&skipped); err != nil {
+				return errors.Wrapf(err, "could not parse Azure %s response", responseType)
+			}
+			continue
+		}
+
+		if err := expectDelim(dec, '[', responseType); err != nil {
+			return err
+		}
+
+		for dec.More() {
+			if err := decodeElement(dec); err != nil {
+				return err
+			}
+		}
+
+		if err := expectDelim(dec, ']', responseType); err != nil {
+			return err
 		}
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
-		return "", errors.Wrap(err, "could not parse Azure items response")
+	return nil
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim, responseType string) error {
+	token, err := dec.Token()
+	if err != nil {
+		return errors.Wrapf(err, "could not parse Azure %s response", responseType)
 	}
 
-	if len(items.Value) == 0 || items.Value[0].CommitId == "" {
-		return "", errors.Errorf("failed to get latest commitID in the repository")
+	if token != delim {
+		return errors.Errorf("could not parse Azure %s response: expected %v, got %v", responseType, delim, token)
 	}
 
-	return items.Value[0].CommitId, nil
+	return nil
 }
 
 func parseUrl(rawUrl string) (*azureOptions, error) {
-	if strings.HasPrefix(rawUrl, "https://") || strings.HasPrefix(rawUrl, "http://") {
-		return parseHttpUrl(rawUrl)
+	opt, err := parseUrlComponents(rawUrl)
+	if err != nil {
+		return nil, err
 	}
-	if strings.HasPrefix(rawUrl, "git@ssh") {
+
+	if err := validateAzureOptions(opt, rawUrl); err != nil {
+		return nil, err
+	}
+
+	return opt, nil
+}
+
+// RepositoryRef holds the components of an Azure DevOps repository URL
+type RepositoryRef struct {
+	// Host is the host name of the URL, e.g. dev.azure.com, organisation.visualstudio.com or ssh.dev.azure.com
+	Host         string
+	Organisation string
+	Project      string
+	Repository   string
+}
+
+// ParseRepositoryURL returns the components of an Azure DevOps repository URL, in the HTTPS, SSH or
+// legacy visualstudio.com forms. Credentials embedded in the URL aren't part of the result,
+// and URLs which don't name the project are rejected.
+func ParseRepositoryURL(rawUrl string) (RepositoryRef, error) {
+	opt, err := parseUrl(rawUrl)
+	if err != nil {
+		return RepositoryRef{}, err
+	}
+
+	return opt.repositoryRef(), nil
+}
+
+func (opt *azureOptions) repositoryRef() RepositoryRef {
+	return RepositoryRef{
+		Host:         opt.host,
+		Organisation: opt.organisation,
+		Project:      opt.project,
+		Repository:   opt.repository,
+	}
+}
+
+// parseUrlComponents parses rawUrl without validating its components,
+// the project being empty when an HTTP url omits it
+func parseUrlComponents(rawUrl string) (*azureOptions, error) {
+	switch {
+	case strings.HasPrefix(rawUrl, "https://") || strings.HasPrefix(rawUrl, "http://"):
+		return parseHttpUrl(rawUrl)
+	case strings.HasPrefix(rawUrl, "git@ssh"), strings.HasPrefix(rawUrl, "ssh://"):
 		return parseSshUrl(rawUrl)
+	default:
+		return nil, errors.Errorf("supported url schemes are https and ssh; recevied URL %s", redactURL(rawUrl))
+	}
+}
+
+// validateScopePath checks that scopePath is a folder path of the repository and returns it in the /folder/subfolder form,
+// the root folder being /
+func validateScopePath(scopePath string) (string, error) {
+	if strings.IndexFunc(scopePath, unicode.IsControl) >= 0 || strings.Contains(scopePath, `\`) {
+		return "", errors.Errorf("invalid scope path %q", scopePath)
+	}
+
+	for _, segment := range strings.Split(strings.Trim(scopePath, "/"), "/") {
+		if segment == "." || segment == ".." {
+			return "", errors.Errorf("invalid scope path %q, it must not contain relative segments", scopePath)
+		}
+	}
+
+	return path.Clean("/" + scopePath), nil
+}
+
+// validateReferenceName checks that referenceName may be a ref name or a commit id, following the rules of
+// git check-ref-format. An empty name selects the default branch and is valid.
+func validateReferenceName(referenceName string) error {
+	if referenceName == "" {
+		return nil
+	}
+
+	if strings.IndexFunc(referenceName, func(r rune) bool { return unicode.IsControl(r) || unicode.IsSpace(r) }) >= 0 ||
+		strings.ContainsAny(referenceName, `~^:?*[\`) ||
+		strings.Contains(referenceName, "..") ||
+		strings.Contains(referenceName, "@{") ||
+		strings.Contains(referenceName, "//") ||
+		strings.HasPrefix(referenceName, "/") ||
+		strings.HasSuffix(referenceName, "/") ||
+		strings.HasSuffix(referenceName, ".") ||
+		strings.HasSuffix(referenceName, ".lock") {
+		return errors.Errorf("invalid reference name %q", referenceName)
+	}
+
+	return nil
+}
+
+// validateAzureOptions checks that the organisation, project and repository parsed from rawUrl
+// can be used as segments of the API URLs
+func validateAzureOptions(opt *azureOptions, rawUrl string) error {
+	components := []struct {
+		name  string
+		value string
+	}{
+		{"organisation", opt.organisation},
+		{"project", opt.project},
+		{"repository", opt.repository},
+	}
+
+	for _, component := range components {
+		if err := validateAzureComponent(component.name, component.value, rawUrl); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateAzureComponent checks that the value of the named component of rawUrl can be used as a segment of the API URLs
+func validateAzureComponent(name, value, rawUrl string) error {
+	if strings.TrimSpace(value) == "" {
+		return errors.Errorf("empty %s in url %s", name, redactURL(rawUrl))
+	}
+
+	if value == "." || value == ".." ||
+		strings.ContainsAny(value, `/\?#`) ||
+		strings.IndexFunc(value, unicode.IsControl) >= 0 {
+		return errors.Errorf("invalid %s %q in url %s", name, value, redactURL(rawUrl))
+	}
+
+	return nil
+}
+
+// redactURL removes the credentials a user may embed in a URL,
+// so that it can safely be part of errors and logs
+func redactURL(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		// URLs such as git@ssh.dev.azure.com:v3/... can't be parsed, strip anything
+		// between the scheme and the last @ of the authority instead
+		return userInfoPattern.ReplaceAllString(rawUrl, "$1")
 	}
-	if strings.HasPrefix(rawUrl, "ssh://") {
-		r := []rune(rawUrl)
-		return parseSshUrl(string(r[6:])) // remove the prefix
+
+	u.User = nil
+	return u.String()
+}
+
+var userInfoPattern = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9+.-]*://)[^/?#]*@`)
+
+// redactURLError removes credentials from the URL carried by a *url.Error
+func redactURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		urlErr.URL = redactURL(urlErr.URL)
 	}
 
-	return nil, errors.Errorf("supported url schemes are https and ssh; recevied URL %s rawUrl", rawUrl)
+	return err
 }
 
 var expectedSshUrl = "git@ssh.dev.azure.com:v3/Organisation/Project/Repository"
 
+// sshPortPattern matches the port following the host of an ssh:// url
+var sshPortPattern = regexp.MustCompile(`^[0-9]+/`)
+
+// parseSshUrl parses both the scp-like git@ssh.dev.azure.com:v3/Organisation/Project/Repository form
+// and the ssh://git@ssh.dev.azure.com[:port]/[v3/]Organisation/Project/Repository form,
+// the v3 segment being optional in both
 func parseSshUrl(rawUrl string) (*azureOptions, error) {
-	path := strings.Split(rawUrl, "/")
+	unexpectedUrlErr := errors.Errorf("want url %s, got %s", expectedSshUrl, redactURL(rawUrl))
+
+	s := strings.TrimPrefix(rawUrl, "ssh://")
+	hostEnd := strings.IndexAny(s, ":/")
+	if hostEnd < 0 {
+		return nil, unexpectedUrlErr
+	}
 
-	unexpectedUrlErr := errors.Errorf("want url %s, got %s", expectedSshUrl, rawUrl)
-	if len(path) != 4 {
+	repositoryPath := s[hostEnd+1:]
+	if s[hostEnd] == ':' {
+		repositoryPath = sshPortPattern.ReplaceAllString(repositoryPath, "")
+	}
+	repositoryPath = strings.TrimPrefix(repositoryPath, "v3/")
+
+	path := strings.Split(repositoryPath, "/")
+	if len(path) != 3 {
 		return nil, unexpectedUrlErr
 	}
+	host := s[:hostEnd]
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		host = host[at+1:]
+	}
+
 	return &azureOptions{
-		organisation: path[1],
-		project:      path[2],
-		repository:   path[3],
+		host:         host,
+		organisation: path[0],
+		project:      path[1],
+		repository:   path[2],
 	}, nil
 }
 
-const expectedAzureDevOpsHttpUrl = "https://Organisation@dev.azure.com/Organisation/Project/_git/Repository"
-const expectedVisualStudioHttpUrl = "https://organisation.visualstudio.com/project/_git/repository"
+const expectedAzureDevOpsHttpUrl = "https://Organisation@dev.azure.com/Organisation/[Project/]_git/Repository"
+const expectedVisualStudioHttpUrl = "https://organisation.visualstudio.com/[collection/][project/]_git/repository"
 
 func parseHttpUrl(rawUrl string) (*azureOptions, error) {
 	u, err := url.Parse(rawUrl)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to parse HTTP url")
+		return nil, errors.Wrap(redactURLError(err), "failed to parse HTTP url")
 	}
 
 	opt := azureOptions{}
 	switch {
 	case u.Host == azureDevOpsHost:
+		// the project may be omitted, e.g. /Organisation/_git/Repository, it's then resolved from the repository
 		path := strings.Split(u.Path, "/")
-		if len(path) != 5 {
-			return nil, errors.Errorf("want url %s, got %s", expectedAzureDevOpsHttpUrl, u)
+		gitMarker := indexOf(path, "_git")
+		if gitMarker < 2 || gitMarker > 3 || len(path) != gitMarker+2 {
+			return nil, errors.Errorf("want url %s, got %s", expectedAzureDevOpsHttpUrl, redactURL(rawUrl))
 		}
 		opt.organisation = path[1]
-		opt.project = path[2]
-		opt.repository = path[4]
+		if gitMarker == 3 {
+			opt.project = path[2]
+		} else {
+			opt.projectOmitted = true
+		}
+		opt.repository = path[gitMarker+1]
 	case strings.HasSuffix(u.Host, visualStudioHostSuffix):
+		// legacy urls name the project collection before the project, e.g. /DefaultCollection/project/_git/repository,
+		// the project may be omitted, e.g. /_git/repository
 		path := strings.Split(u.Path, "/")
-		if len(path) != 4 {
-			return nil, errors.Errorf("want url %s, got %s", expectedVisualStudioHttpUrl, u)
+		gitMarker := indexOf(path, "_git")
+		if gitMarker < 1 || gitMarker > 3 || len(path) != gitMarker+2 {
+			return nil, errors.Errorf("want url %s, got %s", expectedVisualStudioHttpUrl, redactURL(rawUrl))
 		}
 		opt.organisation = strings.TrimSuffix(u.Host, visualStudioHostSuffix)
-		opt.project = path[1]
-		opt.repository = path[3]
+		if gitMarker > 1 {
+			opt.project = path[gitMarker-1]
+		} else {
+			opt.projectOmitted = true
+		}
+		opt.repository = path[gitMarker+1]
+		opt.apiBaseUrl = "https://" + u.Host
+		if gitMarker == 3 {
+			if path[1] == "" {
+				return nil, errors.Errorf("want url %s, got %s", expectedVisualStudioHttpUrl, redactURL(rawUrl))
+			}
+			opt.apiBaseUrl += "/" + url.PathEscape(path[1])
+		}
 	default:
-		return nil, errors.Errorf("unknown azure host in url \"%s\"", rawUrl)
+		return nil, errors.Errorf("unknown azure host in url \"%s\"", redactURL(rawUrl))
 	}
 
+	opt.host = u.Hostname()
 	opt.username = u.User.Username()
 	opt.password, _ = u.User.Password()
 
 	return &opt, nil
 }
 
-func (a *azureDownloader) buildDownloadUrl(config *azureOptions, referenceName string) (string, error) {
-	rawUrl := fmt.Sprintf("%s/%s/%s/_apis/git/repositories/%s/items",
+// indexOf returns the index of the first occurrence of value in values, or -1 when it's missing
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// repositoryApiUrl returns the url of the git API of the repository, to which the endpoint paths are appended
+func (a *azureDownloader) repositoryApiUrl(config *azureOptions) string {
+	if config.apiBaseUrl != "" {
+		return fmt.Sprintf("%s/%s/_apis/git/repositories/%s",
+			config.apiBaseUrl,
+			url.PathEscape(config.project),
+			url.PathEscape(config.repository))
+	}
+
+	return fmt.Sprintf("%s/%s/%s/_apis/git/repositories/%s",
 		a.baseUrl,
 		url.PathEscape(config.organisation),
 		url.PathEscape(config.project),
 		url.PathEscape(config.repository))
+}
+
+func (a *azureDownloader) buildDownloadUrl(config *azureOptions, referenceName string, level recursionLevel) (string, error) {
+	return a.buildFolderDownloadUrl(config, referenceName, "/", level)
+}
+
+// buildFolderDownloadUrl builds the url of the zip archive of the folder at scopePath at the referenceName version
+func (a *azureDownloader) buildFolderDownloadUrl(config *azureOptions, referenceName, scopePath string, level recursionLevel) (string, error) {
+	rawUrl := a.repositoryApiUrl(config) + "/items"
 	u, err := url.Parse(rawUrl)
 
 	if err != nil {
-		return "", errors.Wrapf(err, "failed to parse download url path %s", rawUrl)
+		return "", errors.Wrapf(redactURLError(err), "failed to parse download url path %s", redactURL(rawUrl))
 	}
 	q := u.Query()
 	// scopePath=/&download=true&versionDescriptor.version=main&$format=zip&recursionLevel=full&api-version=6.0
-	q.Set("scopePath", "/")
+	q.Set("scopePath", "/"+strings.Trim(scopePath, "/"))
 	q.Set("download", "true")
 	if referenceName != "" {
 		q.Set("versionDescriptor.versionType", getVersionType(referenceName))
 		q.Set("versionDescriptor.version", formatReferenceName(referenceName))
 	}
 	q.Set("$format", "zip")
-	q.Set("recursionLevel", "full")
-	q.Set("api-version", "6.0")
+	if level == "" {
+		level = recursionLevelFull
+	}
+	q.Set("recursionLevel", string(level))
+	q.Set("api-version", azureAPIVersion)
 	u.RawQuery = q.Encode()
 
 	return u.String(), nil
 }
 
 func (a *azureDownloader) buildRootItemUrl(config *azureOptions, referenceName string) (string, error) {
-	rawUrl := fmt.Sprintf("%s/%s/%s/_apis/git/repositories/%s/items",
-		a.baseUrl,
-		url.PathEscape(config.organisation),
-		url.PathEscape(config.project),
-		url.PathEscape(config.repository))
+	return a.buildItemUrl(config, referenceName, "/")
+}
+
+func (a *azureDownloader) buildItemUrl(config *azureOptions, referenceName, scopePath string) (string, error) {
+	rawUrl := a.repositoryApiUrl(config) + "/items"
+	u, err := url.Parse(rawUrl)
+
+	if err != nil {
+		return "", errors.Wrapf(redactURLError(err), "failed to parse item url path %s", redactURL(rawUrl))
+	}
+
+	q := u.Query()
+	q.Set("scopePath", "/"+strings.TrimPrefix(scopePath, "/"))
+	if referenceName != "" {
+		q.Set("versionDescriptor.versionType", getVersionType(referenceName))
+		q.Set("versionDescriptor.version", formatReferenceName(referenceName))
+	}
+	q.Set("api-version", azureAPIVersion)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// buildItemMetadataUrl builds the url of the metadata of the item at itemPath, without its content
+func (a *azureDownloader) buildItemMetadataUrl(config *azureOptions, referenceName, itemPath string) (string, error) {
+	rawUrl := a.repositoryApiUrl(config) + "/items"
+	u, err := url.Parse(rawUrl)
+
+	if err != nil {
+		return "", errors.Wrapf(redactURLError(err), "failed to parse item metadata url path %s", redactURL(rawUrl))
+	}
+
+	q := u.Query()
+	q.Set("scopePath", "/"+strings.Trim(itemPath, "/"))
+	q.Set("recursionLevel", string(recursionLevelNone))
+	q.Set("$format", "json")
+	if referenceName != "" {
+		q.Set("versionDescriptor.versionType", getVersionType(referenceName))
+		q.Set("versionDescriptor.version", formatReferenceName(referenceName))
+	}
+	q.Set("api-version", azureAPIVersion)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// buildItemsUrl builds the url listing recursively the items under scopePath
+func (a *azureDownloader) buildItemsUrl(config *azureOptions, referenceName, scopePath string) (string, error) {
+	rawUrl := a.repositoryApiUrl(config) + "/items"
+	u, err := url.Parse(rawUrl)
+
+	if err != nil {
+		return "", errors.Wrapf(redactURLError(err), "failed to parse items url path %s", redactURL(rawUrl))
+	}
+
+	q := u.Query()
+	q.Set("scopePath", "/"+strings.Trim(scopePath, "/"))
+	q.Set("recursionLevel", string(recursionLevelFull))
+	if referenceName != "" {
+		q.Set("versionDescriptor.versionType", getVersionType(referenceName))
+		q.Set("versionDescriptor.version", formatReferenceName(referenceName))
+	}
+	q.Set("api-version", azureAPIVersion)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+func (a *azureDownloader) buildFileUrl(config *azureOptions, referenceName, filePath string) (string, error) {
+	rawUrl := a.repositoryApiUrl(config) + "/items"
 	u, err := url.Parse(rawUrl)
 
 	if err != nil {
-		return "", errors.Wrapf(err, "failed to parse root item url path %s", rawUrl)
+		return "", errors.Wrapf(redactURLError(err), "failed to parse file url path %s", redactURL(rawUrl))
 	}
 
 	q := u.Query()
-	q.Set("scopePath", "/")
+	q.Set("path", "/"+strings.TrimPrefix(filePath, "/"))
+	q.Set("download", "true")
 	if referenceName != "" {
 		q.Set("versionDescriptor.versionType", getVersionType(referenceName))
 		q.Set("versionDescriptor.version", formatReferenceName(referenceName))
 	}
-	q.Set("api-version", "6.0")
+	q.Set("api-version", azureAPIVersion)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+func (a *azureDownloader) buildRepositoryUrl(config *azureOptions) (string, error) {
+	rawUrl := a.repositoryApiUrl(config)
+	u, err := url.Parse(rawUrl)
+
+	if err != nil {
+		return "", errors.Wrapf(redactURLError(err), "failed to parse repository url path %s", redactURL(rawUrl))
+	}
+
+	q := u.Query()
+	q.Set("api-version", azureAPIVersion)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+func (a *azureDownloader) buildRefsUrl(config *azureOptions) (string, error) {
+	rawUrl := a.repositoryApiUrl(config) + "/refs"
+	u, err := url.Parse(rawUrl)
+
+	if err != nil {
+		return "", errors.Wrapf(redactURLError(err), "failed to parse refs url path %s", redactURL(rawUrl))
+	}
+
+	q := u.Query()
+	q.Set("peelTags", "true")
+	q.Set("api-version", azureAPIVersion)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// buildTreeUrl builds the url listing the tree rootObjectHash. The tree endpoint is either recursive or not,
+// so the levels other than full list the direct children of the tree.
+func (a *azureDownloader) buildTreeUrl(config *azureOptions, rootObjectHash string, level recursionLevel) (string, error) {
+	rawUrl := fmt.Sprintf("%s/trees/%s", a.repositoryApiUrl(config), url.PathEscape(rootObjectHash))
+	u, err := url.Parse(rawUrl)
+
+	if err != nil {
+		return "", errors.Wrapf(redactURLError(err), "failed to parse tree url path %s", redactURL(rawUrl))
+	}
+
+	q := u.Query()
+	q.Set("recursive", strconv.FormatBool(level == "" || level == recursionLevelFull))
+	q.Set("api-version", azureAPIVersion)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+func (a *azureDownloader) buildCommitUrl(config *azureOptions, commitId string) (string, error) {
+	rawUrl := fmt.Sprintf("%s/commits/%s", a.repositoryApiUrl(config), url.PathEscape(commitId))
+	u, err := url.Parse(rawUrl)
+
+	if err != nil {
+		return "", errors.Wrapf(redactURLError(err), "failed to parse commit url path %s", redactURL(rawUrl))
+	}
+
+	q := u.Query()
+	q.Set("api-version", azureAPIVersion)
 	u.RawQuery = q.Encode()
 
 	return u.String(), nil
@@ -285,6 +2034,8 @@ func formatReferenceName(name string) string {
 	return name
 }
 
+// getVersionType returns the Azure DevOps version type of name. Names other than the full branch and tag names
+// are commits when they look like a commit id, and short branch names such as main otherwise.
 func getVersionType(name string) string {
 	if strings.HasPrefix(name, branchPrefix) {
 		return "branch"
@@ -292,5 +2043,8 @@ func getVersionType(name string) string {
 	if strings.HasPrefix(name, tagPrefix) {
 		return "tag"
 	}
-	return "commit"
+	if commitIdPattern.MatchString(name) {
+		return "commit"
+	}
+	return "branch"
 }
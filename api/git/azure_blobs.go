@@ -0,0 +1,109 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+
+	"github.com/pkg/errors"
+)
+
+// objectIdPattern matches the full id of a git object
+var objectIdPattern = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+
+// downloadBlobs streams to w a zip archive of the blobs with the given object ids, fetched in a single request.
+// The archive entries are named after the object ids. The download stops with ErrArchiveTooLarge once
+// the configured maximum archive size is exceeded, in which case w has received a truncated archive.
+func (a *azureDownloader) downloadBlobs(ctx context.Context, options fetchOptions, objectIds []string, w io.Writer) error {
+	ctx, span := a.startSpan(ctx, "azure.downloadBlobs")
+	defer span.End()
+
+	if len(objectIds) == 0 {
+		return errors.New("no blob to download")
+	}
+
+	for _, objectId := range objectIds {
+		if !objectIdPattern.MatchString(objectId) {
+			return errors.Errorf("invalid blob object id %q", objectId)
+		}
+	}
+
+	config, err := a.parseRepositoryUrl(ctx, options.repositoryUrl, options.username, options.password)
+	if err != nil {
+		return errors.WithMessage(err, "failed to parse url")
+	}
+
+	blobsUrl, err := a.buildBlobsUrl(config)
+	if err != nil {
+		return errors.WithMessage(err, "failed to build azure blobs url")
+	}
+
+	body, err := json.Marshal(objectIds)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode the blob object ids")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", blobsUrl, bytes.NewReader(body))
+	if err != nil {
+		return errors.WithMessage(err, "failed to create a new HTTP request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/zip")
+	// the archive is already compressed
+	req.Header.Set("Accept-Encoding", "identity")
+
+	if err := a.authorize(ctx, req, options.username, options.password, config); err != nil {
+		return err
+	}
+
+	res, err := a.do(req)
+	if err != nil {
+		return errors.WithMessage(redactURLError(err), "failed to make an HTTP request")
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.WithMessage(newAzureHTTPError(res), "failed to download blobs")
+	}
+
+	if a.maxArchiveBytes > 0 && res.ContentLength > a.maxArchiveBytes {
+		return errors.Wrapf(ErrArchiveTooLarge, "archive of %d bytes exceeds the limit of %d bytes", res.ContentLength, a.maxArchiveBytes)
+	}
+
+	reader := a.throttle(ctx, res.Body)
+	if a.maxArchiveBytes > 0 {
+		reader = io.LimitReader(reader, a.maxArchiveBytes+1)
+	}
+
+	written, err := io.Copy(w, reader)
+	span.SetAttribute("download.bytes", written)
+	if err != nil {
+		return errors.WithMessage(err, "failed to stream the blobs archive")
+	}
+
+	if a.maxArchiveBytes > 0 && written > a.maxArchiveBytes {
+		return errors.Wrapf(ErrArchiveTooLarge, "archive exceeds the limit of %d bytes", a.maxArchiveBytes)
+	}
+
+	return nil
+}
+
+// buildBlobsUrl builds the url of the batch download of blobs as a zip archive
+func (a *azureDownloader) buildBlobsUrl(config *azureOptions) (string, error) {
+	rawUrl := a.repositoryApiUrl(config) + "/blobs"
+	u, err := url.Parse(rawUrl)
+
+	if err != nil {
+		return "", errors.Wrapf(redactURLError(err), "failed to parse blobs url path %s", redactURL(rawUrl))
+	}
+
+	q := u.Query()
+	q.Set("api-version", azureAPIVersion)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
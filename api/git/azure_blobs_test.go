@@ -0,0 +1,66 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_azureDownloader_downloadBlobs(t *testing.T) {
+	objectIds := []string{"c1eb0f7b4bf9ca3ee5aa2ae4eba6cc6c1a6f1ab4", "d7e1f29aa1d5d1b0b1e8f27e2a5b0c8e3e0a2f11"}
+	zipArchive := newZipArchive(t, map[string]string{
+		objectIds[0]: "version: '3'",
+		objectIds[1]: "trigger: none",
+	})
+
+	var requestedIds []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || !strings.HasSuffix(r.URL.Path, "/Repository/blobs") {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		assert.Equal(t, "application/zip", r.Header.Get("Accept"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&requestedIds))
+		w.Write(zipArchive)
+	}))
+	defer server.Close()
+
+	a := &azureDownloader{
+		client:  server.Client(),
+		baseUrl: server.URL,
+	}
+
+	options := fetchOptions{repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository"}
+
+	var buf bytes.Buffer
+	err := a.downloadBlobs(context.Background(), options, objectIds, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, objectIds, requestedIds)
+	assert.Equal(t, zipArchive, buf.Bytes())
+}
+
+func Test_azureDownloader_DownloadBlobs_invalidObjectIds(t *testing.T) {
+	a := &azureDownloader{
+		client:  http.DefaultClient,
+		baseUrl: "http://azure.example.invalid",
+	}
+
+	options := fetchOptions{repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository"}
+
+	for _, objectIds := range [][]string{
+		nil,
+		{"c1eb0f7"},
+		{"c1eb0f7b4bf9ca3ee5aa2ae4eba6cc6c1a6f1ab4", "../../refs"},
+	} {
+		err := a.downloadBlobs(context.Background(), options, objectIds, &bytes.Buffer{})
+		assert.Error(t, err, "object ids %v should be rejected", objectIds)
+	}
+}
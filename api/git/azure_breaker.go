@@ -0,0 +1,137 @@
+package git
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// WithCircuitBreaker stops sending requests to a host for cooldown once threshold consecutive
+// requests to it failed, with a network error or a server error status, failing them with ErrCircuitOpen
+// instead. The requests cancelled or timed out by their context, including while waiting for a free connection,
+// don't count as failures. After the cooldown a single probe request is let through, closing the circuit
+// when it succeeds. A zero threshold disables the circuit breaker.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) AzureOption {
+	return func(a *azureDownloader) {
+		a.breakerThreshold = threshold
+		a.breakerCooldown = cooldown
+	}
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// circuitBreaker tracks the consecutive failures of the requests to a host
+type circuitBreaker struct {
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+}
+
+// hostBreaker returns the circuit breaker of host, or nil when the circuit breaker is disabled
+func (a *azureDownloader) hostBreaker(host string) *circuitBreaker {
+	if a.breakerThreshold <= 0 {
+		return nil
+	}
+
+	a.breakersMu.Lock()
+	defer a.breakersMu.Unlock()
+
+	if a.breakers == nil {
+		a.breakers = make(map[string]*circuitBreaker)
+	}
+
+	breaker, ok := a.breakers[host]
+	if !ok {
+		breaker = &circuitBreaker{}
+		a.breakers[host] = breaker
+	}
+
+	return breaker
+}
+
+// allow reports whether a request can be sent, turning an open circuit half-open once the cooldown elapsed
+func (b *circuitBreaker) allow(now time.Time, cooldown time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		if now.Sub(b.openedAt) < cooldown {
+			return false
+		}
+		b.state = circuitHalfOpen
+		return true
+	case circuitHalfOpen:
+		// a probe request is already in flight
+		return false
+	default:
+		return true
+	}
+}
+
+// record updates the circuit with the outcome of a request
+func (b *circuitBreaker) record(now time.Time, failed bool, threshold int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !failed {
+		b.state = circuitClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == circuitHalfOpen || b.failures >= threshold {
+		b.state = circuitOpen
+		b.openedAt = now
+	}
+}
+
+// abandon lets another probe through when the probe request was cancelled before telling whether the host recovered
+func (b *circuitBreaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitHalfOpen {
+		b.state = circuitOpen
+	}
+}
+
+// sendThroughBreaker sends the request unless the circuit of its host is open
+func (a *azureDownloader) sendThroughBreaker(req *http.Request) (*http.Response, error) {
+	breaker := a.hostBreaker(req.URL.Host)
+	if breaker == nil {
+		return a.send(req)
+	}
+
+	if !breaker.allow(a.now(), a.breakerCooldown) {
+		return nil, errors.Wrapf(ErrCircuitOpen, "requests to %s are suspended after repeated failures", req.URL.Host)
+	}
+
+	resp, err := a.send(req)
+	if err != nil && isContextError(req.Context(), err) {
+		// the caller gave up, which tells nothing about the host
+		breaker.abandon()
+		return resp, err
+	}
+
+	breaker.record(a.now(), err != nil || resp.StatusCode >= http.StatusInternalServerError, a.breakerThreshold)
+
+	return resp, err
+}
+
+// isContextError returns whether the request with context ctx failed with err because ctx was cancelled
+// or timed out, rather than because of the transport. The timeouts of the HTTP client itself are transport errors.
+func isContextError(ctx context.Context, err error) bool {
+	return ctx.Err() != nil || errors.Is(err, context.Canceled)
+}
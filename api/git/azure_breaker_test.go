@@ -0,0 +1,131 @@
+package git
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_WithCircuitBreaker(t *testing.T) {
+	var requests int32
+	var healthy atomic.Value
+	healthy.Store(false)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if !healthy.Load().(bool) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(rootItemResponse))
+	}))
+	defer server.Close()
+
+	cooldown := time.Minute
+	clock := newFakeClock()
+	a := NewAzureDownloader(server.Client(), WithCircuitBreaker(3, cooldown), WithClock(clock))
+	a.baseUrl = server.URL
+
+	options := fetchOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := a.latestCommitID(context.Background(), options)
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrCircuitOpen)
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+
+	clock.Advance(cooldown - time.Second)
+	_, err := a.latestCommitID(context.Background(), options)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests), "open circuit should not send requests")
+
+	// a failing probe opens the circuit again
+	clock.Advance(time.Second)
+	_, err = a.latestCommitID(context.Background(), options)
+	assert.NotErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&requests))
+
+	_, err = a.latestCommitID(context.Background(), options)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	// a successful probe closes the circuit
+	healthy.Store(true)
+	clock.Advance(cooldown)
+	for i := 0; i < 2; i++ {
+		_, err = a.latestCommitID(context.Background(), options)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(6), atomic.LoadInt32(&requests))
+}
+
+func Test_WithCircuitBreaker_clientErrorsDoNotTrip(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	a := NewAzureDownloader(server.Client(), WithCircuitBreaker(1, time.Hour))
+	a.baseUrl = server.URL
+
+	for i := 0; i < 3; i++ {
+		_, err := a.latestCommitID(context.Background(), fetchOptions{
+			repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+			referenceName: "refs/heads/main",
+		})
+		assert.ErrorIs(t, err, ErrIncorrectRepositoryURL)
+	}
+}
+
+func Test_WithCircuitBreaker_contextErrorsDoNotTrip(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	a := NewAzureDownloader(server.Client(), WithCircuitBreaker(1, time.Hour), WithLookupTimeout(10*time.Millisecond))
+	a.baseUrl = server.URL
+
+	options := fetchOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for i := 0; i < 3; i++ {
+		_, err := a.latestCommitID(ctx, options)
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrCircuitOpen, "cancelled requests shouldn't open the circuit")
+
+		_, err = a.latestCommitID(context.Background(), options)
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrCircuitOpen, "timed out lookups shouldn't open the circuit")
+	}
+}
+
+func Test_circuitBreaker_abandonedProbe(t *testing.T) {
+	now := time.Now()
+	breaker := &circuitBreaker{}
+	breaker.record(now, true, 1)
+
+	assert.True(t, breaker.allow(now.Add(time.Minute), time.Minute))
+	assert.False(t, breaker.allow(now.Add(time.Minute), time.Minute), "a single probe should be in flight")
+
+	breaker.abandon()
+	assert.True(t, breaker.allow(now.Add(time.Minute), time.Minute), "another probe should replace the abandoned one")
+}
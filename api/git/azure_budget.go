@@ -0,0 +1,86 @@
+package git
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+// maxDownloadBudgetBurst bounds the bytes the downloads may read at once from the budget,
+// so that large budgets still smooth the throughput
+const maxDownloadBudgetBurst = 256 * 1024
+
+// WithGlobalDownloadBudget caps the combined throughput of all the archive and blob downloads of the downloader
+// to bytesPerSecond, the downloads drawing from a shared token bucket. Waiting for the budget is interrupted
+// when the context of the download is done. Zero or less means unlimited.
+func WithGlobalDownloadBudget(bytesPerSecond int64) AzureOption {
+	return func(a *azureDownloader) {
+		if bytesPerSecond <= 0 {
+			a.downloadBudget = nil
+			return
+		}
+
+		burst := bytesPerSecond
+		if burst > maxDownloadBudgetBurst {
+			burst = maxDownloadBudgetBurst
+		}
+		a.downloadBudget = rate.NewLimiter(rate.Limit(bytesPerSecond), int(burst))
+	}
+}
+
+// throttle makes the reads of r draw from the download budget, r being returned as is without budget
+func (a *azureDownloader) throttle(ctx context.Context, r io.Reader) io.Reader {
+	if a.downloadBudget == nil {
+		return r
+	}
+
+	return &throttledReader{ctx: ctx, limiter: a.downloadBudget, clock: a.clockOrDefault(), r: r}
+}
+
+// throttledReader draws the bytes read from the budget, reserving them and waiting on the downloader clock
+type throttledReader struct {
+	ctx     context.Context
+	limiter *rate.Limiter
+	clock   Clock
+	r       io.Reader
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// a read can't take more than the bucket holds
+	if burst := t.limiter.Burst(); len(p) > burst {
+		p = p[:burst]
+	}
+
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if waitErr := t.wait(n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
+
+// wait reserves n bytes of the budget and waits until they're available, giving them back when the context is done
+func (t *throttledReader) wait(n int) error {
+	now := t.clock.Now()
+	reservation := t.limiter.ReserveN(now, n)
+	if !reservation.OK() {
+		return errors.Errorf("failed to reserve %d bytes of the download budget", n)
+	}
+
+	delay := reservation.DelayFrom(now)
+	if delay <= 0 {
+		return nil
+	}
+
+	select {
+	case <-t.ctx.Done():
+		reservation.CancelAt(t.clock.Now())
+		return errors.Wrap(t.ctx.Err(), "download budget wait cancelled")
+	case <-t.clock.After(delay):
+		return nil
+	}
+}
@@ -0,0 +1,74 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_WithGlobalDownloadBudget(t *testing.T) {
+	const budget = 64 * 1024
+	payload := bytes.Repeat([]byte("a"), budget)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	defer server.Close()
+
+	clock := newFakeClock()
+	a := NewAzureDownloader(server.Client(), WithGlobalDownloadBudget(budget), WithClock(clock))
+	a.baseUrl = server.URL
+
+	options := cloneOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	}
+
+	start := clock.Now()
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = a.downloadArchive(context.Background(), ioutil.Discard, options)
+		}(i)
+	}
+	wg.Wait()
+	elapsed := clock.Now().Sub(start)
+
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	// the first second of budget is available at once, the second download has to wait for the next one
+	assert.GreaterOrEqual(t, int64(elapsed), int64(time.Second), "two downloads of a second of budget each took %v", elapsed)
+}
+
+func Test_WithGlobalDownloadBudget_cancelled(t *testing.T) {
+	const budget = 1024
+	payload := bytes.Repeat([]byte("a"), 10*budget)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(payload)
+	}))
+	defer server.Close()
+
+	a := NewAzureDownloader(server.Client(), WithGlobalDownloadBudget(budget))
+	a.baseUrl = server.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := a.downloadArchive(ctx, ioutil.Discard, cloneOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	})
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second), "the download should stop waiting for the budget once cancelled")
+}
@@ -0,0 +1,253 @@
+package git
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	portainer "github.com/portainer/portainer/api"
+	"golang.org/x/sync/semaphore"
+)
+
+// defaultUserAgent identifies the requests sent by the downloader in the Azure DevOps logs
+var defaultUserAgent = "portainer-git/" + portainer.APIVersion
+
+// do sends an HTTP request on behalf of the downloader. When a token source is configured and the token
+// is rejected, presumably because it expired in flight, the request is retried once with a fresh token.
+func (a *azureDownloader) do(req *http.Request) (*http.Response, error) {
+	resp, err := a.doOnce(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || a.tokenSource == nil ||
+		!strings.HasPrefix(req.Header.Get("Authorization"), "Bearer ") {
+		return resp, err
+	}
+
+	retryReq, err := a.reauthorize(req)
+	if err != nil {
+		a.log().Warnf("azure token rejected and refresh failed: %v", err)
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	a.log().Debugf("azure token rejected, retrying %s %s with a fresh token", req.Method, redactURL(req.URL.String()))
+	return a.doOnce(retryReq)
+}
+
+// reauthorize returns a copy of req authorized with a token freshly obtained from the token source
+func (a *azureDownloader) reauthorize(req *http.Request) (*http.Request, error) {
+	retryReq := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, errors.New("the request body can't be sent again")
+		}
+
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to rewind the request body")
+		}
+		retryReq.Body = body
+	}
+
+	a.invalidateToken()
+	token, err := a.token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	retryReq.Header.Set("Authorization", "Bearer "+token)
+
+	return retryReq, nil
+}
+
+// doOnce sends an HTTP request on behalf of the downloader and records it on the current span
+func (a *azureDownloader) doOnce(req *http.Request) (*http.Response, error) {
+	span := spanFromContext(req.Context())
+	span.SetAttribute("http.url", redactURL(req.URL.String()))
+
+	a.applyExtraHeaders(req)
+	req.Header.Set("User-Agent", a.userAgentOrDefault())
+
+	start := a.now()
+	resp, err := a.sendThroughBreaker(req)
+	a.recordRequest(req, a.since(start))
+	if err != nil {
+		a.log().Debugf("azure request %s %s failed after %v: %v", req.Method, redactURL(req.URL.String()), a.since(start), err)
+		return nil, err
+	}
+	a.log().Debugf("azure request %s %s returned %d in %v", req.Method, redactURL(req.URL.String()), resp.StatusCode, a.since(start))
+
+	a.recordRateLimit(resp)
+
+	span.SetAttribute("http.status_code", resp.StatusCode)
+	span.SetAttribute("http.response_content_length", resp.ContentLength)
+
+	return resp, nil
+}
+
+// applyExtraHeaders adds the configured extra headers to req,
+// leaving untouched the headers already set, such as the Authorization of the credentials
+func (a *azureDownloader) applyExtraHeaders(req *http.Request) {
+	for key, values := range a.extraHeaders {
+		if _, ok := req.Header[key]; ok {
+			continue
+		}
+
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+}
+
+// userAgentOrDefault returns the configured User-Agent, defaulting to portainer-git/<version>
+func (a *azureDownloader) userAgentOrDefault() string {
+	if a.userAgent != "" {
+		return a.userAgent
+	}
+
+	return defaultUserAgent
+}
+
+// send sends the request. When a concurrency limit is configured, the request waits
+// for a free slot of its host and holds it until the response body is closed.
+func (a *azureDownloader) send(req *http.Request) (*http.Response, error) {
+	sem := a.hostSemaphore(req.URL.Host)
+	if sem == nil {
+		return a.client.Do(req)
+	}
+
+	if err := sem.Acquire(req.Context(), 1); err != nil {
+		return nil, errors.Wrapf(err, "failed to wait for a free connection to %s", req.URL.Host)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		sem.Release(1)
+		return nil, err
+	}
+
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() { sem.Release(1) }}
+	return resp, nil
+}
+
+// hostSemaphore returns the semaphore limiting the concurrent requests to host,
+// or nil when the concurrency is unlimited
+func (a *azureDownloader) hostSemaphore(host string) *semaphore.Weighted {
+	if a.maxConcurrentRequests <= 0 {
+		return nil
+	}
+
+	a.semaphoresMu.Lock()
+	defer a.semaphoresMu.Unlock()
+
+	if a.semaphores == nil {
+		a.semaphores = make(map[string]*semaphore.Weighted)
+	}
+
+	sem, ok := a.semaphores[host]
+	if !ok {
+		sem = semaphore.NewWeighted(int64(a.maxConcurrentRequests))
+		a.semaphores[host] = sem
+	}
+
+	return sem
+}
+
+// releasingBody calls release once the response body gets closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	closed  bool
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	if !b.closed {
+		b.closed = true
+		b.release()
+	}
+
+	return err
+}
+
+// readCloser reads from Reader and closes Closer, the source Reader decodes
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// gzipBody closes the gzip reader along with the response body it decompresses
+type gzipBody struct {
+	*gzip.Reader
+	body io.Closer
+}
+
+func (b gzipBody) Close() error {
+	err := b.Reader.Close()
+	if bodyErr := b.body.Close(); err == nil {
+		err = bodyErr
+	}
+
+	return err
+}
+
+// responseBody returns the response body, decompressed when the server gzip encoded it.
+// Requests explicitly accepting gzip are not transparently decompressed by the transport.
+// Closing the returned body closes the response body as well.
+func responseBody(resp *http.Response) (io.ReadCloser, error) {
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		return resp.Body, nil
+	}
+
+	reader, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decompress the response")
+	}
+
+	return gzipBody{Reader: reader, body: resp.Body}, nil
+}
+
+// decodeJSON decodes the, possibly gzip encoded, JSON response body into v
+func decodeJSON(resp *http.Response, v interface{}) error {
+	body, err := jsonResponseBody(resp)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return json.NewDecoder(body).Decode(v)
+}
+
+// jsonResponseBody returns the decompressed body of a JSON endpoint response, failing with ErrUnexpectedResponse
+// when it's an HTML page instead, as served by sign-in redirects and misconfigured proxies.
+// Closing the returned body closes the response body as well.
+func jsonResponseBody(resp *http.Response) (io.ReadCloser, error) {
+	body, err := responseBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType == "text/html" {
+		body.Close()
+		return nil, errors.Wrapf(ErrUnexpectedResponse, "got a %s response", mediaType)
+	}
+
+	reader := bufio.NewReader(body)
+	for {
+		b, err := reader.Peek(1)
+		if err != nil || !unicode.IsSpace(rune(b[0])) {
+			break
+		}
+		reader.Discard(1)
+	}
+
+	if b, err := reader.Peek(1); err == nil && b[0] == '<' {
+		body.Close()
+		return nil, errors.Wrap(ErrUnexpectedResponse, "got a markup response")
+	}
+
+	return readCloser{Reader: reader, Closer: body}, nil
+}
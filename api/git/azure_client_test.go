@@ -0,0 +1,249 @@
+package git
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const rootItemResponse = `{"count": 1, "value": [{"objectId": "1a5630f017127db7de24d8771da0f536ff98fc9b", "commitId": "27104ad7549d9e66685e115a497533f18024be9c"}]}`
+
+func Test_azureDownloader_maxConcurrentRequests(t *testing.T) {
+	var inFlight, maxInFlight int32
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if current <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, current) {
+				break
+			}
+		}
+
+		select {
+		case blocked <- struct{}{}:
+		default:
+		}
+		<-release
+		w.Write([]byte(rootItemResponse))
+	}))
+	defer slowServer.Close()
+
+	fastServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(rootItemResponse))
+	}))
+	defer fastServer.Close()
+
+	a := NewAzureDownloader(&http.Client{}, WithMaxConcurrentRequests(1))
+
+	get := func(ctx context.Context, serverUrl string) error {
+		req, err := http.NewRequestWithContext(ctx, "GET", serverUrl, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := a.do(req)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, get(context.Background(), slowServer.URL))
+		}()
+	}
+
+	<-blocked
+
+	// a request to a different host proceeds while the slow host is saturated
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.NoError(t, get(ctx, fastServer.URL))
+
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), maxInFlight, "requests to the same host should be serialized")
+}
+
+func Test_azureDownloader_maxConcurrentRequests_contextCancelled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(rootItemResponse))
+	}))
+	defer server.Close()
+
+	a := NewAzureDownloader(server.Client(), WithMaxConcurrentRequests(1))
+	a.baseUrl = server.URL
+	options := fetchOptions{repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository"}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.latestCommitID(context.Background(), options)
+	}()
+
+	// wait for the first request to take the only slot
+	for a.hostSemaphore(server.Listener.Addr().String()).TryAcquire(1) {
+		a.hostSemaphore(server.Listener.Addr().String()).Release(1)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := a.latestCommitID(ctx, options)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(release)
+	<-done
+}
+
+func Test_azureDownloader_gzipResponses(t *testing.T) {
+	var acceptEncodings []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncodings = append(acceptEncodings, r.Header.Get("Accept-Encoding"))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		gz.Write([]byte(`{
+		  "count": 2,
+		  "value": [
+			{"name": "refs/heads/main", "objectId": "27104ad7549d9e66685e115a497533f18024be9c"},
+			{"name": "refs/tags/v1.0", "objectId": "4f3e6a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f"}
+		  ]
+		}`))
+	}))
+	defer server.Close()
+
+	a := &azureDownloader{
+		client:  server.Client(),
+		baseUrl: server.URL,
+	}
+
+	refs, err := a.listRemote(context.Background(), fetchOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"refs/heads/main", "refs/tags/v1.0"}, refs)
+	assert.Equal(t, []string{"gzip"}, acceptEncodings)
+}
+
+// closeRecorder records whether the body was closed
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func Test_responseBody_closesGzipAndResponseBodies(t *testing.T) {
+	compressed := &bytes.Buffer{}
+	gz := gzip.NewWriter(compressed)
+	gz.Write([]byte(rootItemResponse))
+	gz.Close()
+
+	recorder := &closeRecorder{Reader: compressed}
+	resp := &http.Response{
+		Header: http.Header{"Content-Encoding": []string{"gzip"}},
+		Body:   recorder,
+	}
+
+	body, err := responseBody(resp)
+	assert.NoError(t, err)
+
+	content, err := io.ReadAll(body)
+	assert.NoError(t, err)
+	assert.Equal(t, rootItemResponse, string(content))
+
+	assert.NoError(t, body.Close())
+	assert.True(t, recorder.closed)
+}
+
+func Test_azureDownloader_zipDownloadDoesNotAcceptGzip(t *testing.T) {
+	var acceptEncoding string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	a := &azureDownloader{
+		client:  server.Client(),
+		baseUrl: server.URL,
+	}
+
+	_, err := a.downloadZipFromAzureDevOps(context.Background(), cloneOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	})
+	assert.Error(t, err)
+	assert.Equal(t, "identity", acceptEncoding)
+}
+
+func Test_azureDownloader_htmlResponse(t *testing.T) {
+	signInPage := `
+<!DOCTYPE html>
+<html><head><title>Sign in to your account</title></head><body></body></html>`
+
+	tests := []struct {
+		name        string
+		contentType string
+	}{
+		{name: "html content type", contentType: "text/html; charset=utf-8"},
+		{name: "html body", contentType: "application/json"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Write([]byte(signInPage))
+			}))
+			defer server.Close()
+
+			a := &azureDownloader{
+				client:  server.Client(),
+				baseUrl: server.URL,
+			}
+
+			options := fetchOptions{
+				repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+				referenceName: "refs/heads/main",
+			}
+
+			_, err := a.latestCommitID(context.Background(), options)
+			assert.ErrorIs(t, err, ErrUnexpectedResponse)
+			assert.Contains(t, err.Error(), "please check the credentials and the proxy settings")
+
+			_, err = a.listTreeDetailed(context.Background(), fetchOptions{
+				repositoryUrl: options.repositoryUrl,
+				referenceName: "27104ad7549d9e66685e115a497533f18024be9c",
+				pathPrefix:    "stacks",
+			})
+			assert.ErrorIs(t, err, ErrUnexpectedResponse)
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package git
+
+import "time"
+
+// Clock tells the time to the downloader, which relies on it for token expiry, circuit breaker cooldowns,
+// retry backoffs and request durations
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// WithClock makes the downloader read the time from clock instead of the system clock
+func WithClock(clock Clock) AzureOption {
+	return func(a *azureDownloader) {
+		a.clock = clock
+	}
+}
+
+// now returns the current time of the downloader clock
+func (a *azureDownloader) now() time.Time {
+	return a.clockOrDefault().Now()
+}
+
+// since returns the time elapsed since t on the downloader clock
+func (a *azureDownloader) since(t time.Time) time.Duration {
+	return a.now().Sub(t)
+}
+
+func (a *azureDownloader) clockOrDefault() Clock {
+	if a.clock == nil {
+		return systemClock{}
+	}
+
+	return a.clock
+}
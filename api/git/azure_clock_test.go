@@ -0,0 +1,130 @@
+package git
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a clock advancing only when told to, or when waited upon
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// After advances the clock by d and fires right away
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.Advance(d)
+
+	ch := make(chan time.Time, 1)
+	ch <- c.Now()
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+func Test_WithClock_tokenExpiry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(rootItemResponse))
+	}))
+	defer server.Close()
+
+	clock := newFakeClock()
+	tokenRequests := 0
+	a := NewAzureDownloader(server.Client(), WithClock(clock), WithTokenSource(func(ctx context.Context) (string, time.Time, error) {
+		tokenRequests++
+		return "token", clock.Now().Add(5 * time.Minute), nil
+	}))
+	a.baseUrl = server.URL
+
+	options := fetchOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	}
+
+	_, err := a.latestCommitID(context.Background(), options)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, tokenRequests)
+
+	clock.Advance(3 * time.Minute)
+	_, err = a.latestCommitID(context.Background(), options)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, tokenRequests, "token should still be cached")
+
+	// within the refresh margin of the expiry
+	clock.Advance(90 * time.Second)
+	_, err = a.latestCommitID(context.Background(), options)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, tokenRequests, "token should be refreshed")
+}
+
+func Test_WithClock_circuitBreakerCooldown(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	clock := newFakeClock()
+	a := NewAzureDownloader(server.Client(), WithClock(clock), WithCircuitBreaker(1, time.Minute))
+	a.baseUrl = server.URL
+
+	options := fetchOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	}
+
+	_, err := a.latestCommitID(context.Background(), options)
+	assert.NotErrorIs(t, err, ErrCircuitOpen)
+
+	clock.Advance(59 * time.Second)
+	_, err = a.latestCommitID(context.Background(), options)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 1, requests)
+
+	clock.Advance(time.Second)
+	_, err = a.latestCommitID(context.Background(), options)
+	assert.NotErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 2, requests)
+}
+
+func Test_WithClock_retryBackoff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	clock := newFakeClock()
+	start := clock.Now()
+	a := NewAzureDownloader(server.Client(), WithClock(clock), WithDownloadRetries(3, time.Hour))
+	a.baseUrl = server.URL
+
+	_, err := a.downloadZipFromAzureDevOps(context.Background(), cloneOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 7*time.Hour, clock.Now().Sub(start), "backoffs of 1h, 2h and 4h should have been waited on the clock")
+}
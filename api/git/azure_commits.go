@@ -0,0 +1,195 @@
+package git
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// commitsPageSize is the number of commits requested per page
+const commitsPageSize = 100
+
+// Commit describes a repository commit
+type Commit struct {
+	ID          string
+	Comment     string
+	AuthorName  string
+	AuthorEmail string
+	// AuthorDate is when the change was originally made, zero when unknown
+	AuthorDate time.Time
+	// CommitterDate is when the commit was created, which orders commits as they were pushed
+	// even when rebased or cherry-picked changes keep their original author date. Zero when unknown.
+	CommitterDate time.Time
+}
+
+type azureCommit struct {
+	CommitId string `json:"commitId"`
+	Comment  string `json:"comment"`
+	Author   struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+		Date  string `json:"date"`
+	} `json:"author"`
+	Committer struct {
+		Date string `json:"date"`
+	} `json:"committer"`
+}
+
+// azureDateLayouts are the layouts of the dates found in Azure DevOps responses,
+// fractional seconds being accepted after the seconds whatever their precision
+var azureDateLayouts = []string{
+	time.RFC3339,
+	// dates without time zone are UTC
+	"2006-01-02T15:04:05",
+}
+
+// azureLegacyDatePattern matches the Microsoft JSON date format of older APIs, e.g. /Date(1622628000000)/
+var azureLegacyDatePattern = regexp.MustCompile(`^/Date\((-?[0-9]+)([+-][0-9]{4})?\)/$`)
+
+// parseAzureDate parses an Azure DevOps date, an empty date being the zero time
+func parseAzureDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if match := azureLegacyDatePattern.FindStringSubmatch(value); match != nil {
+		milliseconds, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return time.Time{}, errors.Wrapf(err, "invalid date %q", value)
+		}
+		return time.Unix(0, milliseconds*int64(time.Millisecond)).UTC(), nil
+	}
+
+	for _, layout := range azureDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, errors.Errorf("invalid date %q", value)
+}
+
+// toCommit converts the commit of an Azure DevOps response
+func (c azureCommit) toCommit() (Commit, error) {
+	authorDate, err := parseAzureDate(c.Author.Date)
+	if err != nil {
+		return Commit{}, errors.WithMessagef(err, "failed to parse the author date of commit %s", c.CommitId)
+	}
+
+	committerDate, err := parseAzureDate(c.Committer.Date)
+	if err != nil {
+		return Commit{}, errors.WithMessagef(err, "failed to parse the committer date of commit %s", c.CommitId)
+	}
+
+	return Commit{
+		ID:            c.CommitId,
+		Comment:       c.Comment,
+		AuthorName:    c.Author.Name,
+		AuthorEmail:   c.Author.Email,
+		AuthorDate:    authorDate,
+		CommitterDate: committerDate,
+	}, nil
+}
+
+// commitsSince returns the commits of the options ref that aren't reachable from sinceCommit, newest first.
+// It's empty when the ref still points to sinceCommit.
+func (a *azureDownloader) commitsSince(ctx context.Context, options fetchOptions, sinceCommit string) ([]Commit, error) {
+	config, err := a.parseRepositoryUrl(ctx, options.repositoryUrl, options.username, options.password)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to parse url")
+	}
+
+	referenceName, err := a.referenceNameOrDefault(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := a.lookupContext(ctx)
+	defer cancel()
+
+	commits := []Commit{}
+	for skip := 0; ; skip += commitsPageSize {
+		commitsUrl, err := a.buildCommitsUrl(config, referenceName, sinceCommit, skip)
+		if err != nil {
+			return nil, errors.WithMessage(err, "failed to build azure commits url")
+		}
+
+		page, err := a.getCommits(ctx, commitsUrl, options, config)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, azureCommit := range page {
+			commit, err := azureCommit.toCommit()
+			if err != nil {
+				return nil, err
+			}
+			commits = append(commits, commit)
+		}
+
+		if len(page) < commitsPageSize {
+			return commits, nil
+		}
+	}
+}
+
+func (a *azureDownloader) getCommits(ctx context.Context, commitsUrl string, options fetchOptions, config *azureOptions) ([]azureCommit, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", commitsUrl, nil)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to create a new HTTP request")
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	if err := a.authorize(ctx, req, options.username, options.password, config); err != nil {
+		return nil, err
+	}
+
+	resp, err := a.do(req)
+	if err != nil {
+		return nil, errors.WithMessage(redactURLError(err), "failed to make an HTTP request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.WithMessage(newAzureHTTPError(resp), "failed to get repository commits")
+	}
+
+	var commits struct {
+		Value []azureCommit `json:"value"`
+	}
+
+	if err := decodeJSON(resp, &commits); err != nil {
+		return nil, errors.Wrap(err, "could not parse Azure commits response")
+	}
+
+	return commits.Value, nil
+}
+
+// buildCommitsUrl builds the url of the commits of referenceName that aren't reachable from sinceCommit
+func (a *azureDownloader) buildCommitsUrl(config *azureOptions, referenceName, sinceCommit string, skip int) (string, error) {
+	rawUrl := a.repositoryApiUrl(config) + "/commits"
+	u, err := url.Parse(rawUrl)
+
+	if err != nil {
+		return "", errors.Wrapf(redactURLError(err), "failed to parse commits url path %s", redactURL(rawUrl))
+	}
+
+	q := u.Query()
+	q.Set("searchCriteria.itemVersion.versionType", getVersionType(referenceName))
+	q.Set("searchCriteria.itemVersion.version", formatReferenceName(referenceName))
+	q.Set("searchCriteria.compareVersion.versionType", "commit")
+	q.Set("searchCriteria.compareVersion.version", sinceCommit)
+	q.Set("searchCriteria.$top", strconv.Itoa(commitsPageSize))
+	if skip > 0 {
+		q.Set("searchCriteria.$skip", strconv.Itoa(skip))
+	}
+	q.Set("api-version", azureAPIVersion)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
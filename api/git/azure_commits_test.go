@@ -0,0 +1,126 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_azureDownloader_commitsSince(t *testing.T) {
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Write([]byte(`{
+		  "count": 2,
+		  "value": [
+			{
+			  "commitId": "5a4e6a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f",
+			  "comment": "Scale the web service",
+			  "author": {"name": "Jane Doe", "email": "jane@example.com", "date": "2021-06-02T10:00:00Z"},
+			  "committer": {"name": "Jane Doe", "email": "jane@example.com", "date": "2021-06-03T08:30:15.1234567Z"}
+			},
+			{
+			  "commitId": "4f3e6a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f",
+			  "comment": "Update the nginx image",
+			  "author": {"name": "John Doe", "email": "john@example.com", "date": "2021-06-01T10:00:00Z"},
+			  "committer": {"name": "John Doe", "email": "john@example.com", "date": "2021-06-01T12:00:00+02:00"}
+			}
+		  ]
+		}`))
+	}))
+	defer server.Close()
+
+	a := &azureDownloader{
+		client:  server.Client(),
+		baseUrl: server.URL,
+	}
+
+	commits, err := a.commitsSince(context.Background(), fetchOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	}, "27104ad7549d9e66685e115a497533f18024be9c")
+	assert.NoError(t, err)
+	assert.Equal(t, []Commit{
+		{
+			ID: "5a4e6a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f", Comment: "Scale the web service", AuthorName: "Jane Doe", AuthorEmail: "jane@example.com",
+			AuthorDate:    time.Date(2021, 6, 2, 10, 0, 0, 0, time.UTC),
+			CommitterDate: time.Date(2021, 6, 3, 8, 30, 15, 123456700, time.UTC),
+		},
+		{
+			ID: "4f3e6a2b1c0d9e8f7a6b5c4d3e2f1a0b9c8d7e6f", Comment: "Update the nginx image", AuthorName: "John Doe", AuthorEmail: "john@example.com",
+			AuthorDate:    time.Date(2021, 6, 1, 10, 0, 0, 0, time.UTC),
+			CommitterDate: time.Date(2021, 6, 1, 12, 0, 0, 0, time.FixedZone("", 2*60*60)),
+		},
+	}, commits)
+
+	assert.Equal(t, "branch", query.Get("searchCriteria.itemVersion.versionType"))
+	assert.Equal(t, "main", query.Get("searchCriteria.itemVersion.version"))
+	assert.Equal(t, "commit", query.Get("searchCriteria.compareVersion.versionType"))
+	assert.Equal(t, "27104ad7549d9e66685e115a497533f18024be9c", query.Get("searchCriteria.compareVersion.version"))
+}
+
+func Test_azureDownloader_commitsSince_pages(t *testing.T) {
+	total := commitsPageSize + 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		skip, _ := strconv.Atoi(r.URL.Query().Get("searchCriteria.$skip"))
+
+		var values []string
+		for i := skip; i < total && i < skip+commitsPageSize; i++ {
+			values = append(values, fmt.Sprintf(`{"commitId": "%040d"}`, i))
+		}
+		w.Write([]byte(`{"value": [` + strings.Join(values, ",") + `]}`))
+	}))
+	defer server.Close()
+
+	a := &azureDownloader{
+		client:  server.Client(),
+		baseUrl: server.URL,
+	}
+
+	commits, err := a.commitsSince(context.Background(), fetchOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	}, "27104ad7549d9e66685e115a497533f18024be9c")
+	assert.NoError(t, err)
+	if assert.Len(t, commits, total) {
+		assert.Equal(t, fmt.Sprintf("%040d", 0), commits[0].ID, "commits should be newest first")
+		assert.Equal(t, fmt.Sprintf("%040d", total-1), commits[total-1].ID)
+	}
+}
+
+func Test_parseAzureDate(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "2021-06-02T10:00:00Z", want: time.Date(2021, 6, 2, 10, 0, 0, 0, time.UTC)},
+		{value: "2021-06-02T10:00:00.1234567Z", want: time.Date(2021, 6, 2, 10, 0, 0, 123456700, time.UTC)},
+		{value: "2021-06-02T12:00:00+02:00", want: time.Date(2021, 6, 2, 10, 0, 0, 0, time.UTC)},
+		{value: "2021-06-02T10:00:00", want: time.Date(2021, 6, 2, 10, 0, 0, 0, time.UTC)},
+		{value: "/Date(1622628000000)/", want: time.Date(2021, 6, 2, 10, 0, 0, 0, time.UTC)},
+		{value: "/Date(1622628000000+0200)/", want: time.Date(2021, 6, 2, 10, 0, 0, 0, time.UTC)},
+		{value: ""},
+		{value: "yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseAzureDate(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, tt.want.Equal(got), "want %v, got %v", tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package git
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxErrorBodySize is the maximum number of bytes of a response body kept in an AzureHTTPError
+const maxErrorBodySize = 1024
+
+// AzureHTTPError is returned when Azure DevOps answers with an unexpected status
+type AzureHTTPError struct {
+	StatusCode int
+	Status     string
+	// Body holds the beginning of the response body
+	Body string
+	// TypeKey and Message are read from the JSON error payload, when there's one
+	TypeKey string
+	Message string
+	// AuthSchemes are the authentication schemes challenged by the WWW-Authenticate headers of a 401 response,
+	// e.g. Bearer or Basic
+	AuthSchemes []string
+}
+
+// disabledRepositoryErrorCodes are the TF error codes Azure DevOps prefixes the messages
+// about disabled repositories with
+var disabledRepositoryErrorCodes = []string{"TF401019"}
+
+// itemNotFoundTypeKey is the type of the errors about a path missing from a repository,
+// unlike the ones about a missing repository, project or ref
+const itemNotFoundTypeKey = "GitItemNotFoundException"
+
+type azureErrorPayload struct {
+	TypeKey string `json:"typeKey"`
+	Message string `json:"message"`
+}
+
+func newAzureHTTPError(resp *http.Response) *AzureHTTPError {
+	var body []byte
+	if reader, err := responseBody(resp); err == nil {
+		body, _ = io.ReadAll(io.LimitReader(reader, maxErrorBodySize))
+		reader.Close()
+	}
+
+	httpErr := &AzureHTTPError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       string(body),
+	}
+
+	// sign-in pages and proxies answer with HTML, only JSON payloads carry a message
+	var payload azureErrorPayload
+	if json.Unmarshal(body, &payload) == nil {
+		httpErr.TypeKey = payload.TypeKey
+		httpErr.Message = payload.Message
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		httpErr.AuthSchemes = parseAuthSchemes(resp.Header.Values("WWW-Authenticate"))
+	}
+
+	return httpErr
+}
+
+// parseAuthSchemes returns the schemes of the challenges of WWW-Authenticate header values.
+// Parameters are skipped: a challenge is a scheme followed by a space, its parameters are comma-separated
+// key=value pairs, so a comma-separated item without = starts a new challenge.
+func parseAuthSchemes(values []string) []string {
+	var schemes []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			scheme := strings.SplitN(item, " ", 2)[0]
+			if scheme == "" || strings.Contains(scheme, "=") {
+				continue
+			}
+			schemes = append(schemes, scheme)
+		}
+	}
+
+	return schemes
+}
+
+func (e *AzureHTTPError) Error() string {
+	msg := fmt.Sprintf("unexpected status \"%v\"", e.Status)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+
+	if len(e.AuthSchemes) > 0 {
+		msg += fmt.Sprintf(" (the server expects %s authentication)", strings.Join(e.AuthSchemes, " or "))
+	}
+
+	return msg
+}
+
+// Is makes the error match the sentinel errors corresponding to its status and error code
+func (e *AzureHTTPError) Is(target error) bool {
+	switch target {
+	case ErrRepositoryDisabled:
+		return e.repositoryDisabled()
+	case ErrIncorrectRepositoryURL:
+		// a path missing from an existing repository doesn't make the repository URL incorrect
+		return e.StatusCode == http.StatusNotFound && !e.repositoryDisabled() && e.TypeKey != itemNotFoundTypeKey
+	case ErrAuthenticationFailure:
+		// Azure answers with a sign-in page and a 203 status to anonymous requests on private repositories
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusNonAuthoritativeInfo
+	case ErrInsufficientPermissions:
+		return e.StatusCode == http.StatusForbidden
+	}
+
+	return false
+}
+
+func (e *AzureHTTPError) repositoryDisabled() bool {
+	for _, code := range disabledRepositoryErrorCodes {
+		if strings.HasPrefix(e.Message, code+":") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isItemNotFound returns whether err reports a path missing from an existing repository at an existing ref
+func isItemNotFound(err error) bool {
+	var httpErr *AzureHTTPError
+	return errors.As(err, &httpErr) && httpErr.itemNotFound()
+}
+
+func (e *AzureHTTPError) itemNotFound() bool {
+	return e.StatusCode == http.StatusNotFound && e.TypeKey == itemNotFoundTypeKey
+}
@@ -0,0 +1,215 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_azureDownloader_returnsAzureHTTPError(t *testing.T) {
+	tests := []struct {
+		name       string
+		statusCode int
+		sentinel   error
+	}{
+		{name: "not found", statusCode: http.StatusNotFound, sentinel: ErrIncorrectRepositoryURL},
+		{name: "unauthorized", statusCode: http.StatusUnauthorized, sentinel: ErrAuthenticationFailure},
+		{name: "non authoritative", statusCode: http.StatusNonAuthoritativeInfo, sentinel: ErrAuthenticationFailure},
+		{name: "server error", statusCode: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statusCode)
+				w.Write([]byte(`{"message": "server side failure"}` + strings.Repeat(" ", 2*maxErrorBodySize)))
+			}))
+			defer server.Close()
+
+			a := &azureDownloader{
+				client:  server.Client(),
+				baseUrl: server.URL,
+			}
+
+			_, err := a.latestCommitID(context.Background(), fetchOptions{
+				repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+			})
+
+			var httpErr *AzureHTTPError
+			if assert.True(t, errors.As(err, &httpErr)) {
+				assert.Equal(t, tt.statusCode, httpErr.StatusCode)
+				assert.Equal(t, http.StatusText(tt.statusCode), strings.SplitN(httpErr.Status, " ", 2)[1])
+				assert.True(t, strings.HasPrefix(httpErr.Body, `{"message": "server side failure"}`))
+				assert.Len(t, httpErr.Body, maxErrorBodySize)
+			}
+
+			if tt.sentinel != nil {
+				assert.ErrorIs(t, err, tt.sentinel)
+			} else {
+				assert.False(t, errors.Is(err, ErrIncorrectRepositoryURL))
+				assert.False(t, errors.Is(err, ErrAuthenticationFailure))
+			}
+		})
+	}
+}
+
+func Test_azureDownloader_AzureHTTPErrorMessage(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantTypeKey string
+		wantMessage string
+	}{
+		{
+			name:        "json payload",
+			body:        `{"$id": "1", "message": "TF401174: The item 'docker-compose.yml' could not be found.", "typeKey": "GitItemNotFoundException", "errorCode": 0}`,
+			wantTypeKey: "GitItemNotFoundException",
+			wantMessage: "TF401174: The item 'docker-compose.yml' could not be found.",
+		},
+		{
+			name: "html payload",
+			body: `<html><body>Sign in</body></html>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			a := &azureDownloader{
+				client:  server.Client(),
+				baseUrl: server.URL,
+			}
+
+			_, err := a.latestCommitID(context.Background(), fetchOptions{
+				repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+			})
+
+			var httpErr *AzureHTTPError
+			if assert.True(t, errors.As(err, &httpErr)) {
+				assert.Equal(t, tt.wantTypeKey, httpErr.TypeKey)
+				assert.Equal(t, tt.wantMessage, httpErr.Message)
+			}
+
+			if tt.wantMessage != "" {
+				assert.Contains(t, err.Error(), tt.wantMessage)
+			} else {
+				assert.True(t, strings.HasSuffix(err.Error(), `unexpected status "400 Bad Request"`))
+			}
+		})
+	}
+}
+
+func Test_azureDownloader_repositoryDisabled(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		wantDisabled bool
+	}{
+		{
+			name:         "disabled repository",
+			body:         `{"$id": "1", "message": "TF401019: The Git repository with name or identifier Repository does not exist or you do not have permissions for the operation you are attempting.", "typeKey": "GitRepositoryNotFoundException", "errorCode": 0}`,
+			wantDisabled: true,
+		},
+		{
+			name: "unknown error code",
+			body: `{"$id": "1", "message": "TF200016: The following project does not exist: Project.", "typeKey": "ProjectDoesNotExistWithNameException", "errorCode": 0}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			a := &azureDownloader{
+				client:  server.Client(),
+				baseUrl: server.URL,
+			}
+
+			_, err := a.latestCommitID(context.Background(), fetchOptions{
+				repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+			})
+
+			var httpErr *AzureHTTPError
+			assert.True(t, errors.As(err, &httpErr))
+			assert.Equal(t, tt.wantDisabled, errors.Is(err, ErrRepositoryDisabled))
+			assert.Equal(t, !tt.wantDisabled, errors.Is(err, ErrIncorrectRepositoryURL))
+		})
+	}
+}
+
+func Test_azureDownloader_authSchemeHint(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Bearer authorization_uri=https://login.microsoftonline.com/tenant, error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	a := &azureDownloader{
+		client:  server.Client(),
+		baseUrl: server.URL,
+	}
+
+	_, err := a.latestCommitID(context.Background(), fetchOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		username:      "",
+		password:      "personal-access-token",
+	})
+	assert.ErrorIs(t, err, ErrAuthenticationFailure)
+	assert.Contains(t, err.Error(), "the server expects Bearer authentication")
+
+	var httpErr *AzureHTTPError
+	if assert.True(t, errors.As(err, &httpErr)) {
+		assert.Equal(t, []string{"Bearer"}, httpErr.AuthSchemes)
+	}
+}
+
+func Test_parseAuthSchemes(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   []string
+	}{
+		{values: nil, want: nil},
+		{values: []string{`Bearer authorization_uri=https://login.microsoftonline.com/tenant`}, want: []string{"Bearer"}},
+		{values: []string{`Bearer authorization_uri=https://login.microsoftonline.com/tenant, Basic realm="https://dev.azure.com/"`}, want: []string{"Bearer", "Basic"}},
+		{values: []string{`Basic realm="https://dev.azure.com/"`, "TFS-Federated"}, want: []string{"Basic", "TFS-Federated"}},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, parseAuthSchemes(tt.values), "values %q", tt.values)
+	}
+}
+
+func Test_AzureHTTPError_Is_notFound(t *testing.T) {
+	tests := []struct {
+		name          string
+		typeKey       string
+		wantIncorrect bool
+	}{
+		{name: "no type key", wantIncorrect: true},
+		{name: "missing repository", typeKey: "GitRepositoryNotFoundException", wantIncorrect: true},
+		{name: "missing project", typeKey: "ProjectDoesNotExistWithNameException", wantIncorrect: true},
+		{name: "missing item", typeKey: itemNotFoundTypeKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := &AzureHTTPError{StatusCode: http.StatusNotFound, TypeKey: tt.typeKey}
+			assert.Equal(t, tt.wantIncorrect, errors.Is(err, ErrIncorrectRepositoryURL))
+			assert.Equal(t, !tt.wantIncorrect, isItemNotFound(err))
+		})
+	}
+}
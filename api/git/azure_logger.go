@@ -0,0 +1,30 @@
+package git
+
+// Logger receives the diagnostics of the downloader. It's satisfied by most leveled loggers,
+// such as logrus, so that they can be plugged in without an adapter.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Warnf(format string, args ...interface{})
+}
+
+// WithLogger makes the downloader log each request and the access token cache usage at the debug level,
+// and the recoverable failures at the warning level
+func WithLogger(logger Logger) AzureOption {
+	return func(a *azureDownloader) {
+		a.logger = logger
+	}
+}
+
+// log returns the configured logger, the returned logger is never nil
+func (a *azureDownloader) log() Logger {
+	if a.logger == nil {
+		return noopLogger{}
+	}
+
+	return a.logger
+}
+
+type noopLogger struct{}
+
+func (noopLogger) Debugf(string, ...interface{}) {}
+func (noopLogger) Warnf(string, ...interface{})  {}
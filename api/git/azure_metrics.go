@@ -0,0 +1,75 @@
+package git
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Operations reported by MetricsSnapshot
+const (
+	OperationRefs     = "refs"
+	OperationTree     = "tree"
+	OperationRootItem = "rootItem"
+	OperationItem     = "item"
+	OperationDownload = "download"
+	OperationOther    = "other"
+)
+
+// OperationMetrics sums up the requests sent for an operation. The duration of a request
+// covers the wait for its response headers, not the reading of its body.
+type OperationMetrics struct {
+	Count         int64
+	TotalDuration time.Duration
+}
+
+// MetricsSnapshot returns the number and cumulative duration of the requests sent so far, per operation
+func (a *azureDownloader) MetricsSnapshot() map[string]OperationMetrics {
+	a.metricsMu.Lock()
+	defer a.metricsMu.Unlock()
+
+	snapshot := make(map[string]OperationMetrics, len(a.metrics))
+	for operation, metrics := range a.metrics {
+		snapshot[operation] = metrics
+	}
+
+	return snapshot
+}
+
+// recordRequest adds a request of req operation that took duration to the metrics
+func (a *azureDownloader) recordRequest(req *http.Request, duration time.Duration) {
+	operation := requestOperation(req)
+
+	a.metricsMu.Lock()
+	defer a.metricsMu.Unlock()
+
+	if a.metrics == nil {
+		a.metrics = make(map[string]OperationMetrics)
+	}
+
+	metrics := a.metrics[operation]
+	metrics.Count++
+	metrics.TotalDuration += duration
+	a.metrics[operation] = metrics
+}
+
+// requestOperation returns the operation an Azure DevOps API request belongs to
+func requestOperation(req *http.Request) string {
+	path := req.URL.Path
+	query := req.URL.Query()
+
+	switch {
+	case strings.HasSuffix(path, "/refs"):
+		return OperationRefs
+	case strings.Contains(path, "/trees/"):
+		return OperationTree
+	case strings.HasSuffix(path, "/items") && query.Get("$format") == "zip":
+		return OperationDownload
+	case strings.HasSuffix(path, "/items") && query.Get("scopePath") == "/" && query.Get("recursionLevel") == "":
+		return OperationRootItem
+	case strings.HasSuffix(path, "/items"):
+		return OperationItem
+	}
+
+	return OperationOther
+}
@@ -0,0 +1,81 @@
+package git
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_azureDownloader_MetricsSnapshot(t *testing.T) {
+	treeServer := newAzureTreeTestServer(t)
+	defer treeServer.Close()
+
+	zipArchive := newZipArchive(t, map[string]string{"docker-compose.yml": "version: '3'"})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("$format") == "zip" {
+			w.Write(zipArchive)
+			return
+		}
+		treeServer.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	a := NewAzureDownloader(server.Client())
+	a.baseUrl = server.URL
+	assert.Empty(t, a.MetricsSnapshot())
+
+	// resolves the ref, then gets the root item and the tree
+	_, err := a.listTree(context.Background(), fetchOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	})
+	assert.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "azure-metrics-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = a.download(context.Background(), dir, cloneOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	})
+	assert.NoError(t, err)
+
+	metrics := a.MetricsSnapshot()
+	for _, operation := range []string{OperationRefs, OperationRootItem, OperationTree, OperationDownload} {
+		assert.Equal(t, int64(1), metrics[operation].Count, "unexpected %s request count", operation)
+		assert.Greater(t, int64(metrics[operation].TotalDuration), int64(0), "missing %s request duration", operation)
+	}
+	assert.NotContains(t, metrics, OperationItem)
+	assert.NotContains(t, metrics, OperationOther)
+}
+
+func Test_requestOperation(t *testing.T) {
+	base := "https://dev.azure.com/Organisation/Project/_apis/git/repositories/Repository"
+
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: base + "/refs?api-version=6.0", want: OperationRefs},
+		{url: base + "/trees/1a5630f017127db7de24d8771da0f536ff98fc9b?recursive=true", want: OperationTree},
+		{url: base + "/items?scopePath=%2F&api-version=6.0", want: OperationRootItem},
+		{url: base + "/items?scopePath=%2Fstacks&api-version=6.0", want: OperationItem},
+		{url: base + "/items?scopePath=%2F&download=true&$format=zip&recursionLevel=full", want: OperationDownload},
+		{url: base + "/commits/27104ad7549d9e66685e115a497533f18024be9c", want: OperationOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			req, err := http.NewRequest("GET", tt.url, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, requestOperation(req))
+		})
+	}
+}
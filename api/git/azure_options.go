@@ -0,0 +1,317 @@
+package git
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/portainer/portainer/api/archive"
+)
+
+// AzureOption configures an Azure downloader
+type AzureOption func(*azureDownloader)
+
+// WithTimeout sets the overall timeout of the HTTP client used by the downloader.
+// Deadlines of the contexts passed to the downloader compose with the client timeout,
+// whichever expires first cancels the request.
+func WithTimeout(timeout time.Duration) AzureOption {
+	return func(a *azureDownloader) {
+		client := a.cloneClient()
+		client.Timeout = timeout
+		a.client = client
+	}
+}
+
+// WithLookupTimeout bounds the duration of the metadata requests independently of the archive downloads,
+// which are only limited by the client timeout and the caller context. It applies to each request getting
+// the repository, its refs, a commit, an item, the tree, the items list, a single file or the organisation
+// repositories, and to the whole pagination of the commits listed by commitsSince.
+func WithLookupTimeout(timeout time.Duration) AzureOption {
+	return func(a *azureDownloader) {
+		a.lookupTimeout = timeout
+	}
+}
+
+// cloneClient returns a shallow copy of the downloader client,
+// so that options don't alter a client shared with other services
+func (a *azureDownloader) cloneClient() *http.Client {
+	if a.client == nil {
+		return &http.Client{}
+	}
+
+	client := *a.client
+	return &client
+}
+
+// cloneTransport replaces the downloader client by a copy using a copy of its transport,
+// so that transport options don't alter a client shared with other services
+func (a *azureDownloader) cloneTransport() *http.Transport {
+	client := a.cloneClient()
+
+	var transport *http.Transport
+	if t, ok := client.Transport.(*http.Transport); ok && t != nil {
+		transport = t.Clone()
+	} else {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+
+	client.Transport = transport
+	a.client = client
+
+	return transport
+}
+
+// lookupContext derives the context of a metadata request from the caller context
+func (a *azureDownloader) lookupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if a.lookupTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, a.lookupTimeout)
+}
+
+// WithTokenSource makes the downloader authenticate with a bearer token
+// obtained from source before each request, overriding static credentials
+func WithTokenSource(source TokenSource) AzureOption {
+	return func(a *azureDownloader) {
+		a.tokenSource = source
+	}
+}
+
+// WithBasicPAT makes the downloader authenticate with an Azure DevOps personal access token
+// sent as the password of basic auth with an empty username, overriding static credentials.
+// Unlike WithTokenSource, which sends OAuth access tokens as bearer tokens, it suits PATs,
+// which some Azure DevOps endpoints reject as bearer tokens.
+func WithBasicPAT(pat string) AzureOption {
+	return func(a *azureDownloader) {
+		a.basicPAT = pat
+	}
+}
+
+// WithMaxConcurrentRequests limits the number of simultaneous requests the downloader
+// sends to a single host, additional requests wait for a free slot or for their context to be done.
+// Zero means unlimited.
+func WithMaxConcurrentRequests(max int) AzureOption {
+	return func(a *azureDownloader) {
+		a.maxConcurrentRequests = max
+	}
+}
+
+// WithClientCertificate makes the downloader present cert to Azure DevOps servers requiring mutual TLS.
+// It preserves the existing TLS settings of the client transport. Only the requests of this downloader
+// present the certificate: the Azure repositories are never cloned with go-git, and the go-git clones
+// of the other repositories use their own transport.
+func WithClientCertificate(cert tls.Certificate) AzureOption {
+	return func(a *azureDownloader) {
+		transport := a.cloneTransport()
+
+		tlsConfig := &tls.Config{}
+		if transport.TLSClientConfig != nil {
+			tlsConfig = transport.TLSClientConfig.Clone()
+		}
+		tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+
+		transport.TLSClientConfig = tlsConfig
+	}
+}
+
+// WithTransportTuning sets the connection pool settings of the downloader transport: the maximum number
+// of idle connections overall and per host, and how long an idle connection is kept alive.
+// It preserves the other settings of the client transport.
+func WithTransportTuning(maxIdleConns, maxIdleConnsPerHost int, idleConnTimeout time.Duration) AzureOption {
+	return func(a *azureDownloader) {
+		transport := a.cloneTransport()
+		transport.MaxIdleConns = maxIdleConns
+		transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+		transport.IdleConnTimeout = idleConnTimeout
+	}
+}
+
+// WithProxyURL routes the downloader requests through the given http, https or socks5 proxy,
+// regardless of the proxy environment variables. The proxy URL is validated when the option is created.
+func WithProxyURL(proxyUrl string) (AzureOption, error) {
+	u, err := url.Parse(proxyUrl)
+	if err != nil {
+		return nil, errors.Wrap(redactURLError(err), "failed to parse proxy url")
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, errors.Errorf("unsupported proxy scheme %q, supported schemes are http, https and socks5", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return nil, errors.Errorf("missing host in proxy url %s", redactURL(proxyUrl))
+	}
+
+	return func(a *azureDownloader) {
+		a.cloneTransport().Proxy = http.ProxyURL(u)
+	}, nil
+}
+
+// WithExtraHeaders adds headers to every request sent by the downloader,
+// e.g. the tokens required by corporate egress gateways.
+// Headers set by the downloader itself, such as Authorization, take precedence.
+func WithExtraHeaders(headers http.Header) AzureOption {
+	return func(a *azureDownloader) {
+		extraHeaders := make(http.Header, len(headers))
+		for key, values := range headers {
+			extraHeaders[http.CanonicalHeaderKey(key)] = append([]string(nil), values...)
+		}
+		a.extraHeaders = extraHeaders
+	}
+}
+
+// WithUserAgent sets the User-Agent header of every request sent by the downloader,
+// instead of the default portainer-git/<version>
+func WithUserAgent(userAgent string) AzureOption {
+	return func(a *azureDownloader) {
+		a.userAgent = userAgent
+	}
+}
+
+// WithMaxArchiveBytes caps the size of the repository archives the downloader saves to disk.
+// Larger archives are rejected with ErrArchiveTooLarge, as soon as the announced Content-Length
+// exceeds the limit or otherwise once the limit is reached while streaming. Zero means unlimited.
+func WithMaxArchiveBytes(max int64) AzureOption {
+	return func(a *azureDownloader) {
+		a.maxArchiveBytes = max
+	}
+}
+
+// WithTempDir makes the downloader save the archives in dir instead of the system temp directory,
+// dir being created when missing
+func WithTempDir(dir string) AzureOption {
+	return func(a *azureDownloader) {
+		a.tempDir = dir
+	}
+}
+
+// WithMaxFiles caps the number of files extracted from a repository archive. Downloads of archives holding more files
+// fail with archive.ErrExtractLimitExceeded and the files already extracted to the destination are removed.
+// Zero means unlimited.
+func WithMaxFiles(max int) AzureOption {
+	return func(a *azureDownloader) {
+		a.maxFiles = max
+	}
+}
+
+// WithMaxUncompressedBytes caps the total size of the files extracted from a repository archive, which can be much larger
+// than the archive itself. Downloads of archives expanding to more bytes fail with archive.ErrExtractLimitExceeded
+// and the files already extracted to the destination are removed. Zero means unlimited.
+func WithMaxUncompressedBytes(max int64) AzureOption {
+	return func(a *azureDownloader) {
+		a.maxUncompressedBytes = max
+	}
+}
+
+// extractLimits returns the configured limits of the archive extractions
+func (a *azureDownloader) extractLimits() archive.ExtractLimits {
+	return archive.ExtractLimits{
+		MaxFiles:             a.maxFiles,
+		MaxUncompressedBytes: a.maxUncompressedBytes,
+	}
+}
+
+// WithIncludeHidden sets whether the files with a path component starting with a dot, such as .env or .github/workflows/ci.yml,
+// are listed and extracted. They are by default. Files inside a .git folder are never extracted.
+func WithIncludeHidden(include bool) AzureOption {
+	return func(a *azureDownloader) {
+		a.excludeHidden = !include
+	}
+}
+
+// includePath returns whether the file at target is listed or extracted according to the hidden files setting
+func (a *azureDownloader) includePath(target string) bool {
+	return !a.excludeHidden || !isHiddenPath(target)
+}
+
+// extractFilter returns the function selecting the archive entries to extract among the ones accepted by include,
+// a nil include accepting any entry
+func (a *azureDownloader) extractFilter(include func(name string) bool) func(name string) bool {
+	return func(name string) bool {
+		if isGitDirPath(name) || !a.includePath(name) {
+			return false
+		}
+
+		return include == nil || include(name)
+	}
+}
+
+// WithEmptyTreeOnNotFound makes listing the tree of an existing ref return no files instead of an error
+// when Azure DevOps doesn't find its root tree, as for a branch without any file.
+// A missing repository or ref still fails, with ErrIncorrectRepositoryURL or ErrRefNotFound.
+func WithEmptyTreeOnNotFound() AzureOption {
+	return func(a *azureDownloader) {
+		a.emptyTreeOnNotFound = true
+	}
+}
+
+// WithAllowedHosts restricts the repositories the downloader accesses to the ones whose URL host,
+// e.g. dev.azure.com or ssh.dev.azure.com, is in hosts. An entry is either an exact host name or a wildcard
+// such as *.corp.local, matching any subdomain of corp.local but not corp.local itself. Host names are
+// case-insensitive. Other repositories fail with ErrHostNotAllowed before any request is sent.
+// Any host is allowed by default or when hosts is nil, while an empty non-nil list rejects every repository.
+func WithAllowedHosts(hosts []string) AzureOption {
+	return func(a *azureDownloader) {
+		a.allowedHosts = normalizeAllowedHosts(hosts)
+	}
+}
+
+// normalizeAllowedHosts lowercases and trims the allowed hosts, keeping a nil list nil
+func normalizeAllowedHosts(hosts []string) []string {
+	if hosts == nil {
+		return nil
+	}
+
+	normalized := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		normalized = append(normalized, strings.ToLower(strings.TrimSpace(host)))
+	}
+
+	return normalized
+}
+
+// checkHost returns ErrHostNotAllowed when host doesn't match any of the allowed hosts
+func (a *azureDownloader) checkHost(host string) error {
+	return checkAllowedHost(a.allowedHosts, host)
+}
+
+// checkAllowedHost returns ErrHostNotAllowed when host doesn't match any of the normalized allowedHosts,
+// any host being allowed when allowedHosts is nil
+func checkAllowedHost(allowedHosts []string, host string) error {
+	if allowedHosts == nil {
+		return nil
+	}
+
+	host = strings.ToLower(host)
+	for _, allowed := range allowedHosts {
+		if allowed == host {
+			return nil
+		}
+
+		if strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:]) && len(host) > len(allowed)-1 {
+			return nil
+		}
+	}
+
+	return errors.WithMessagef(ErrHostNotAllowed, "host %s", host)
+}
+
+// createTempFile creates a temp file in the configured temp directory, the system one by default
+func (a *azureDownloader) createTempFile(pattern string) (*os.File, error) {
+	if a.tempDir != "" {
+		if err := os.MkdirAll(a.tempDir, 0700); err != nil {
+			return nil, errors.Wrapf(err, "failed to create temp dir %s", a.tempDir)
+		}
+	}
+
+	return ioutil.TempFile(a.tempDir, pattern)
+}
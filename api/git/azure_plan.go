@@ -0,0 +1,66 @@
+package git
+
+import (
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultBranchPlaceholder stands for the default branch, which is only known once the repository is fetched
+	defaultBranchPlaceholder = branchPrefix + "DEFAULT_BRANCH"
+	// treeObjectIdPlaceholder stands for the tree object id, which is only known once the root item is fetched
+	treeObjectIdPlaceholder = "TREE_OBJECT_ID"
+)
+
+// planRequests returns the credential-redacted URLs the downloader would request to list and download
+// the repository, in order and without sending any request. Values only known from previous responses,
+// such as the default branch or the tree object id, are replaced by placeholders.
+// Repositories on hosts which aren't allowed fail with ErrHostNotAllowed.
+func (a *azureDownloader) planRequests(options fetchOptions) ([]string, error) {
+	config, err := parseUrl(options.repositoryUrl)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to parse url")
+	}
+
+	if err := a.checkHost(config.host); err != nil {
+		return nil, err
+	}
+
+	var urls []string
+	referenceName := options.referenceName
+	if referenceName == "" {
+		repositoryUrl, err := a.buildRepositoryUrl(config)
+		if err != nil {
+			return nil, errors.WithMessage(err, "failed to build repository url")
+		}
+		urls = append(urls, repositoryUrl)
+		referenceName = defaultBranchPlaceholder
+	} else {
+		refsUrl, err := a.buildRefsUrl(config)
+		if err != nil {
+			return nil, errors.WithMessage(err, "failed to build refs url")
+		}
+		urls = append(urls, refsUrl)
+	}
+
+	rootItemUrl, err := a.buildItemUrl(config, referenceName, options.scopePath)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to build root item url")
+	}
+
+	treeUrl, err := a.buildTreeUrl(config, treeObjectIdPlaceholder, options.recursionLevel)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to build tree url")
+	}
+
+	downloadUrl, err := a.buildDownloadUrl(config, referenceName, options.recursionLevel)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to build download url")
+	}
+
+	urls = append(urls, rootItemUrl, treeUrl, downloadUrl)
+	for i := range urls {
+		urls[i] = redactURL(urls[i])
+	}
+
+	return urls, nil
+}
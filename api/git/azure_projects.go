@@ -0,0 +1,237 @@
+package git
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type azureRepository struct {
+	Name    string `json:"name"`
+	Project struct {
+		Name string `json:"name"`
+	} `json:"project"`
+}
+
+// ResolveProject returns the name of the project of the repository named repository in the organisation,
+// looked up in the list of the organisation repositories. The repository name is matched case-insensitively.
+// The host of the Azure DevOps API must be one of the allowed hosts.
+func (a *azureDownloader) ResolveProject(ctx context.Context, organisation, repository, username, password string) (string, error) {
+	baseUrl, err := url.Parse(a.baseUrl)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to parse azure base url")
+	}
+
+	if err := a.checkHost(baseUrl.Hostname()); err != nil {
+		return "", err
+	}
+
+	return a.resolveProject(ctx, &azureOptions{organisation: organisation, repository: repository}, username, password)
+}
+
+// parseRepositoryUrl parses repositoryUrl like parseUrl, checking its host against the allowed hosts
+// and resolving the project when the url omits it
+func (a *azureDownloader) parseRepositoryUrl(ctx context.Context, repositoryUrl, username, password string) (*azureOptions, error) {
+	config, err := parseUrlComponents(repositoryUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.checkHost(config.host); err != nil {
+		return nil, err
+	}
+
+	if config.projectOmitted {
+		// the organisation is validated before being part of the lookup url
+		if err := validateAzureComponent("organisation", config.organisation, repositoryUrl); err != nil {
+			return nil, err
+		}
+
+		config.project, err = a.resolveProject(ctx, config, username, password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := validateAzureOptions(config, repositoryUrl); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// Bounds of the cache of the resolved projects
+const (
+	maxCachedProjects = 256
+	projectCacheTTL   = 10 * time.Minute
+)
+
+// cachedProject is a resolved project, looked up again once expired
+type cachedProject struct {
+	project   string
+	expiresAt time.Time
+}
+
+// resolveProject returns the project of the config repository, which is looked up once per credentials
+// and then cached for projectCacheTTL
+func (a *azureDownloader) resolveProject(ctx context.Context, config *azureOptions, username, password string) (string, error) {
+	repositoriesUrl, err := a.buildOrganisationRepositoriesUrl(config)
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to build azure repositories url")
+	}
+
+	// the repositories visible to other credentials may differ, only their hash is kept in memory
+	key := repositoriesUrl + "#" + strings.ToLower(config.repository) + "#" + credentialsHash(username, password, config)
+	if project, ok := a.cachedProject(key); ok {
+		return project, nil
+	}
+
+	repositories, err := a.listOrganisationRepositories(ctx, repositoriesUrl, username, password, config)
+	if err != nil {
+		return "", err
+	}
+
+	project, err := matchRepositoryProject(repositories, config.repository)
+	if err != nil {
+		return "", err
+	}
+
+	a.cacheProject(key, project)
+
+	return project, nil
+}
+
+// credentialsHash returns a hash of the credentials authorize uses for username, password and config
+func credentialsHash(username, password string, config *azureOptions) string {
+	if username == "" && password == "" {
+		username, password = config.username, config.password
+	}
+
+	sum := sha256.Sum256([]byte(username + "\x00" + password))
+	return hex.EncodeToString(sum[:])
+}
+
+// cachedProject returns the project cached for key unless it expired
+func (a *azureDownloader) cachedProject(key string) (string, bool) {
+	a.projectsMu.Lock()
+	defer a.projectsMu.Unlock()
+
+	cached, ok := a.projects[key]
+	if !ok || !a.now().Before(cached.expiresAt) {
+		return "", false
+	}
+
+	return cached.project, true
+}
+
+// cacheProject caches project for key, making room by evicting the expired entries,
+// then the one expiring first, once the cache holds maxCachedProjects entries
+func (a *azureDownloader) cacheProject(key, project string) {
+	a.projectsMu.Lock()
+	defer a.projectsMu.Unlock()
+
+	now := a.now()
+	if a.projects == nil {
+		a.projects = make(map[string]cachedProject)
+	}
+
+	if _, ok := a.projects[key]; !ok && len(a.projects) >= maxCachedProjects {
+		oldestKey := ""
+		for cachedKey, cached := range a.projects {
+			if !now.Before(cached.expiresAt) {
+				delete(a.projects, cachedKey)
+				continue
+			}
+			if oldestKey == "" || cached.expiresAt.Before(a.projects[oldestKey].expiresAt) {
+				oldestKey = cachedKey
+			}
+		}
+
+		if len(a.projects) >= maxCachedProjects {
+			delete(a.projects, oldestKey)
+		}
+	}
+
+	a.projects[key] = cachedProject{project: project, expiresAt: now.Add(projectCacheTTL)}
+}
+
+// matchRepositoryProject returns the project of the repository named name,
+// failing when no repository or several repositories of different projects have this name
+func matchRepositoryProject(repositories []azureRepository, name string) (string, error) {
+	var projects []string
+	for _, repository := range repositories {
+		if strings.EqualFold(repository.Name, name) {
+			projects = append(projects, repository.Project.Name)
+		}
+	}
+
+	switch len(projects) {
+	case 0:
+		return "", errors.Wrapf(ErrIncorrectRepositoryURL, "repository %q not found in the organisation", name)
+	case 1:
+		return projects[0], nil
+	default:
+		return "", errors.Errorf("repository %q exists in several projects (%s), the url must name the project", name, strings.Join(projects, ", "))
+	}
+}
+
+func (a *azureDownloader) listOrganisationRepositories(ctx context.Context, repositoriesUrl, username, password string, config *azureOptions) ([]azureRepository, error) {
+	ctx, cancel := a.lookupContext(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", repositoriesUrl, nil)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to create a new HTTP request")
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	if err := a.authorize(ctx, req, username, password, config); err != nil {
+		return nil, err
+	}
+
+	resp, err := a.do(req)
+	if err != nil {
+		return nil, errors.WithMessage(redactURLError(err), "failed to make an HTTP request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.WithMessage(newAzureHTTPError(resp), "failed to list organisation repositories")
+	}
+
+	var repositories struct {
+		Value []azureRepository `json:"value"`
+	}
+
+	if err := decodeJSON(resp, &repositories); err != nil {
+		return nil, errors.Wrap(err, "could not parse Azure repositories response")
+	}
+
+	return repositories.Value, nil
+}
+
+// buildOrganisationRepositoriesUrl builds the url listing the repositories of all the projects of the config organisation
+func (a *azureDownloader) buildOrganisationRepositoriesUrl(config *azureOptions) (string, error) {
+	rawUrl := fmt.Sprintf("%s/%s/_apis/git/repositories", a.baseUrl, url.PathEscape(config.organisation))
+	if config.apiBaseUrl != "" {
+		rawUrl = config.apiBaseUrl + "/_apis/git/repositories"
+	}
+
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", errors.Wrapf(redactURLError(err), "failed to parse repositories url path %s", redactURL(rawUrl))
+	}
+
+	q := u.Query()
+	q.Set("api-version", azureAPIVersion)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
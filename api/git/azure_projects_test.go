@@ -0,0 +1,149 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const organisationRepositoriesResponse = `{
+	"count": 3,
+	"value": [
+		{"id": "0b8fd2f9-6b04-4e1c-9cd8-0e2e5c6b9a01", "name": "Repository", "project": {"id": "3c3f1d9e-0f4b-4b4e-8b0e-2f1a9d8c7b6a", "name": "Project"}},
+		{"id": "7d6f0a2c-1e3b-4a5d-9c8b-7a6f5e4d3c2b", "name": "Shared", "project": {"id": "3c3f1d9e-0f4b-4b4e-8b0e-2f1a9d8c7b6a", "name": "Project"}},
+		{"id": "9e8d7c6b-5a4f-4e3d-8c2b-1a0f9e8d7c6b", "name": "Shared", "project": {"id": "5a4b3c2d-1e0f-4a9b-8c7d-6e5f4a3b2c1d", "name": "Other"}}
+	]
+}`
+
+func Test_azureDownloader_ResolveProject(t *testing.T) {
+	var requestedPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedPath = r.URL.Path
+		w.Write([]byte(organisationRepositoriesResponse))
+	}))
+	defer server.Close()
+
+	a := &azureDownloader{
+		client:  server.Client(),
+		baseUrl: server.URL,
+	}
+
+	t.Run("matches the repository by name", func(t *testing.T) {
+		project, err := a.ResolveProject(context.Background(), "Organisation", "repository", "", "")
+		assert.NoError(t, err)
+		assert.Equal(t, "Project", project)
+		assert.Equal(t, "/Organisation/_apis/git/repositories", requestedPath)
+	})
+
+	t.Run("unknown repository", func(t *testing.T) {
+		_, err := a.ResolveProject(context.Background(), "Organisation", "Missing", "", "")
+		assert.ErrorIs(t, err, ErrIncorrectRepositoryURL)
+	})
+
+	t.Run("repository name shared by several projects", func(t *testing.T) {
+		_, err := a.ResolveProject(context.Background(), "Organisation", "Shared", "", "")
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "Project, Other")
+		}
+	})
+}
+
+func Test_azureDownloader_resolveProject_cache(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(organisationRepositoriesResponse))
+	}))
+	defer server.Close()
+
+	clock := newFakeClock()
+	a := &azureDownloader{
+		client:  server.Client(),
+		baseUrl: server.URL,
+		clock:   clock,
+	}
+
+	resolve := func(username, password string) {
+		project, err := a.ResolveProject(context.Background(), "Organisation", "Repository", username, password)
+		assert.NoError(t, err)
+		assert.Equal(t, "Project", project)
+	}
+
+	resolve("user", "password")
+	resolve("user", "password")
+	assert.Equal(t, 1, requests, "the project should be cached")
+
+	resolve("other", "password")
+	assert.Equal(t, 2, requests, "the project should be looked up again with other credentials")
+
+	clock.Advance(projectCacheTTL)
+	resolve("user", "password")
+	assert.Equal(t, 3, requests, "an expired project should be looked up again")
+
+	for i := 0; i < maxCachedProjects+10; i++ {
+		resolve(fmt.Sprintf("user%d", i), "password")
+	}
+	assert.LessOrEqual(t, len(a.projects), maxCachedProjects)
+}
+
+func Test_azureDownloader_urlWithoutProject(t *testing.T) {
+	repositoriesRequests := 0
+	var itemPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/Organisation/_apis/git/repositories" {
+			repositoriesRequests++
+			w.Write([]byte(organisationRepositoriesResponse))
+			return
+		}
+
+		itemPath = r.URL.Path
+		w.Write([]byte(`{"count": 1, "value": [{"objectId": "1a5630f017127db7de24d8771da0f536ff98fc9b", "gitObjectType": "tree", "commitId": "27104ad7549d9e66685e115a497533f18024be9c", "path": "/", "isFolder": true}]}`))
+	}))
+	defer server.Close()
+
+	a := &azureDownloader{
+		client:  server.Client(),
+		baseUrl: server.URL,
+	}
+
+	options := fetchOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/_git/Repository",
+		referenceName: "refs/heads/main",
+	}
+
+	for i := 0; i < 2; i++ {
+		commitID, err := a.latestCommitID(context.Background(), options)
+		assert.NoError(t, err)
+		assert.Equal(t, "27104ad7549d9e66685e115a497533f18024be9c", commitID)
+	}
+	assert.Equal(t, "/Organisation/Project/_apis/git/repositories/Repository/items", itemPath)
+	assert.Equal(t, 1, repositoriesRequests, "the project should be resolved once")
+}
+
+func Test_parseUrl_withoutProject(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{name: "Azure DevOps", url: "https://dev.azure.com/Organisation/_git/Repository"},
+		{name: "Visual Studio", url: "https://organisation.visualstudio.com/_git/repository"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := parseUrlComponents(tt.url)
+			assert.NoError(t, err)
+			assert.True(t, config.projectOmitted)
+			assert.Empty(t, config.project)
+
+			_, err = parseUrl(tt.url)
+			if assert.Error(t, err) {
+				assert.Contains(t, err.Error(), "empty project")
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package git
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimit is the Azure DevOps rate limit budget reported by the latest response carrying rate limit headers
+type RateLimit struct {
+	// Resource is the name of the throttled resource, e.g. "ATCPU" or "core"
+	Resource string
+	// Remaining is the number of throughput units left before the requests get delayed
+	Remaining float64
+	// Limit is the total number of throughput units allowed before the requests get delayed
+	Limit float64
+	// Reset is when the usage gets back to zero, zero when unknown
+	Reset time.Time
+	// ObservedAt is when the headers were received, zero when no response reported a rate limit yet
+	ObservedAt time.Time
+}
+
+// RateLimitStatus returns the latest rate limit budget observed on the Azure DevOps responses
+func (a *azureDownloader) RateLimitStatus() RateLimit {
+	a.rateLimitMu.Lock()
+	defer a.rateLimitMu.Unlock()
+
+	return a.rateLimit
+}
+
+// recordRateLimit updates the rate limit status from the X-RateLimit-* headers of resp, if any
+func (a *azureDownloader) recordRateLimit(resp *http.Response) {
+	remaining, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Remaining"), 64)
+	if err != nil {
+		return
+	}
+
+	status := RateLimit{
+		Resource:   resp.Header.Get("X-RateLimit-Resource"),
+		Remaining:  remaining,
+		ObservedAt: a.now(),
+	}
+
+	if limit, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Limit"), 64); err == nil {
+		status.Limit = limit
+	}
+
+	if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		status.Reset = time.Unix(reset, 0)
+	}
+
+	a.rateLimitMu.Lock()
+	defer a.rateLimitMu.Unlock()
+
+	a.rateLimit = status
+}
@@ -0,0 +1,93 @@
+package git
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_azureDownloader_RateLimitStatus(t *testing.T) {
+	var mu sync.Mutex
+	remaining := 200
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		remaining--
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		mu.Unlock()
+
+		w.Header().Set("X-RateLimit-Resource", "ATCPU")
+		w.Header().Set("X-RateLimit-Limit", "200")
+		w.Header().Set("X-RateLimit-Reset", "1700000000")
+		w.Write([]byte(rootItemResponse))
+	}))
+	defer server.Close()
+
+	a := &azureDownloader{
+		client:  server.Client(),
+		baseUrl: server.URL,
+	}
+
+	assert.True(t, a.RateLimitStatus().ObservedAt.IsZero(), "no rate limit should be reported before any request")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := a.latestCommitID(context.Background(), fetchOptions{
+				repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+				referenceName: "refs/heads/main",
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	status := a.RateLimitStatus()
+	assert.Equal(t, "ATCPU", status.Resource)
+	assert.Equal(t, float64(200), status.Limit)
+	assert.GreaterOrEqual(t, status.Remaining, float64(195))
+	assert.Less(t, status.Remaining, float64(200))
+	assert.Equal(t, time.Unix(1700000000, 0), status.Reset)
+	assert.False(t, status.ObservedAt.IsZero())
+}
+
+func Test_azureDownloader_RateLimitStatus_keepsLastObservedValues(t *testing.T) {
+	withHeaders := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if withHeaders {
+			w.Header().Set("X-RateLimit-Resource", "core")
+			w.Header().Set("X-RateLimit-Remaining", "42.5")
+		}
+		w.Write([]byte(rootItemResponse))
+	}))
+	defer server.Close()
+
+	a := &azureDownloader{
+		client:  server.Client(),
+		baseUrl: server.URL,
+	}
+
+	options := fetchOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	}
+
+	_, err := a.latestCommitID(context.Background(), options)
+	assert.NoError(t, err)
+
+	withHeaders = false
+	_, err = a.latestCommitID(context.Background(), options)
+	assert.NoError(t, err)
+
+	status := a.RateLimitStatus()
+	assert.Equal(t, "core", status.Resource)
+	assert.Equal(t, 42.5, status.Remaining)
+	assert.True(t, status.Reset.IsZero())
+}
@@ -0,0 +1,82 @@
+package git
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// defaultMaxRedirects is the number of redirects the downloader follows by default, like the Go HTTP client
+const defaultMaxRedirects = 10
+
+// azureBlobHostSuffix is the suffix of the Azure storage hosts the archive downloads may be redirected to
+const azureBlobHostSuffix = ".blob.core.windows.net"
+
+// WithRedirectPolicy makes the downloader follow at most maxRedirects redirects per request,
+// failing with an error beyond. The credentials are sent along the redirects to Azure DevOps,
+// Azure storage and the given trusted hosts only, and stripped for any other host.
+func WithRedirectPolicy(maxRedirects int, trustedHosts ...string) AzureOption {
+	return func(a *azureDownloader) {
+		a.maxRedirects = maxRedirects
+		a.trustedRedirectHosts = append([]string(nil), trustedHosts...)
+	}
+}
+
+// installRedirectPolicy makes a copy of the downloader client follow the redirects as configured
+func (a *azureDownloader) installRedirectPolicy() {
+	client := a.cloneClient()
+	client.CheckRedirect = a.checkRedirect
+	a.client = client
+}
+
+// checkRedirect caps the number of redirects and sends the credentials of the original request
+// to trusted hosts only, as the Go HTTP client drops them when the host changes
+func (a *azureDownloader) checkRedirect(req *http.Request, via []*http.Request) error {
+	maxRedirects := a.maxRedirects
+	if maxRedirects <= 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+
+	if len(via) > maxRedirects {
+		return errors.Errorf("stopped after %d redirects", maxRedirects)
+	}
+
+	authorization := via[0].Header.Get("Authorization")
+	if authorization == "" {
+		return nil
+	}
+
+	if a.isTrustedRedirect(req.URL, via[0].URL) {
+		req.Header.Set("Authorization", authorization)
+	} else {
+		req.Header.Del("Authorization")
+	}
+
+	return nil
+}
+
+// isTrustedRedirect returns whether the credentials sent to origin may be sent to target
+func (a *azureDownloader) isTrustedRedirect(target, origin *url.URL) bool {
+	// never downgrade credentials to plain HTTP
+	if target.Scheme != origin.Scheme && target.Scheme != "https" {
+		return false
+	}
+
+	host := target.Host
+	if host == origin.Host {
+		return true
+	}
+
+	for _, trusted := range a.trustedRedirectHosts {
+		if strings.EqualFold(host, trusted) {
+			return true
+		}
+	}
+
+	hostname := strings.ToLower(target.Hostname())
+	return hostname == azureDevOpsHost ||
+		strings.HasSuffix(hostname, visualStudioHostSuffix) ||
+		strings.HasSuffix(hostname, azureBlobHostSuffix)
+}
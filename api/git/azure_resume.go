@@ -0,0 +1,44 @@
+package git
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// partialDownload is an archive download interrupted after size bytes were saved to path
+type partialDownload struct {
+	path string
+	etag string
+	size int64
+}
+
+// contentRangeStartsAt returns whether the Content-Range of the partial response resp starts at offset
+func contentRangeStartsAt(resp *http.Response, offset int64) bool {
+	var start, end int64
+	if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/", &start, &end); err != nil {
+		return false
+	}
+
+	return start == offset
+}
+
+// resumableETag returns the strong validator allowing to resume the download of resp with a range request,
+// or an empty string when the server doesn't support ranges or the archive can't be validated
+func resumableETag(resp *http.Response, previous partialDownload) string {
+	if resp.StatusCode != http.StatusPartialContent && resp.Header.Get("Accept-Ranges") != "bytes" {
+		return ""
+	}
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" && resp.StatusCode == http.StatusPartialContent {
+		etag = previous.etag
+	}
+
+	// If-Range only accepts strong validators
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		return ""
+	}
+
+	return etag
+}
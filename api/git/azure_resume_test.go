@@ -0,0 +1,194 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newInterruptingArchiveServer serves archive with range support, aborting the first response halfway through
+func newInterruptingArchiveServer(t *testing.T, etags []string, archives [][]byte, ranges *[]string) *httptest.Server {
+	attempt := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag, archive := etags[attempt], archives[attempt]
+		attempt++
+
+		*ranges = append(*ranges, r.Header.Get("Range"))
+
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("ETag", etag)
+
+		var offset int
+		if rangeHeader := r.Header.Get("Range"); rangeHeader != "" && r.Header.Get("If-Range") == etag {
+			fmt.Sscanf(rangeHeader, "bytes=%d-", &offset)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(archive)-1, len(archive)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(archive)-offset))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(archive[offset:])
+			return
+		}
+
+		w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+		if attempt == 1 {
+			w.Write(archive[:len(archive)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		w.Write(archive)
+	}))
+}
+
+func Test_azureDownloader_resumesInterruptedDownload(t *testing.T) {
+	archive := bytes.Repeat([]byte("0123456789"), 1024)
+
+	var ranges []string
+	server := newInterruptingArchiveServer(t, []string{`"v1"`, `"v1"`}, [][]byte{archive, archive}, &ranges)
+	defer server.Close()
+
+	a := &azureDownloader{
+		client:               server.Client(),
+		baseUrl:              server.URL,
+		downloadRetries:      1,
+		downloadRetryBackoff: time.Millisecond,
+	}
+
+	options := cloneOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	}
+
+	zipFilepath, err := a.downloadZipFromAzureDevOps(context.Background(), options)
+	assert.NoError(t, err)
+	defer os.Remove(zipFilepath)
+
+	assert.Equal(t, []string{"", fmt.Sprintf("bytes=%d-", len(archive)/2)}, ranges)
+
+	content, err := ioutil.ReadFile(zipFilepath)
+	assert.NoError(t, err)
+	assert.Equal(t, archive, content)
+}
+
+func Test_azureDownloader_restartsDownloadWhenArchiveChanged(t *testing.T) {
+	previous := bytes.Repeat([]byte("0123456789"), 1024)
+	current := bytes.Repeat([]byte("abcdefghij"), 1024)
+
+	var ranges []string
+	server := newInterruptingArchiveServer(t, []string{`"v1"`, `"v2"`}, [][]byte{previous, current}, &ranges)
+	defer server.Close()
+
+	a := &azureDownloader{
+		client:               server.Client(),
+		baseUrl:              server.URL,
+		downloadRetries:      1,
+		downloadRetryBackoff: time.Millisecond,
+	}
+
+	options := cloneOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	}
+
+	zipFilepath, err := a.downloadZipFromAzureDevOps(context.Background(), options)
+	assert.NoError(t, err)
+	defer os.Remove(zipFilepath)
+
+	content, err := ioutil.ReadFile(zipFilepath)
+	assert.NoError(t, err)
+	assert.Equal(t, current, content, "a changed archive should be downloaded from the start")
+}
+
+func Test_azureDownloader_doesNotResumeAcrossDownloads(t *testing.T) {
+	archive := bytes.Repeat([]byte("0123456789"), 1024)
+
+	var ranges []string
+	server := newInterruptingArchiveServer(t, []string{`"v1"`, `"v1"`}, [][]byte{archive, archive}, &ranges)
+	defer server.Close()
+
+	tempDir, err := ioutil.TempDir("", "azure-resume-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(tempDir)
+
+	a := &azureDownloader{
+		client:  server.Client(),
+		baseUrl: server.URL,
+		tempDir: tempDir,
+	}
+
+	options := cloneOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	}
+
+	_, err = a.downloadZipFromAzureDevOps(context.Background(), options)
+	assert.Error(t, err)
+
+	files, err := filepath.Glob(filepath.Join(tempDir, "*"))
+	assert.NoError(t, err)
+	assert.Empty(t, files, "the partial archive of a failed download should be removed")
+
+	zipFilepath, err := a.downloadZipFromAzureDevOps(context.Background(), options)
+	assert.NoError(t, err)
+	defer os.Remove(zipFilepath)
+
+	assert.Equal(t, []string{"", ""}, ranges, "another download shouldn't resume the failed one")
+}
+
+func Test_azureDownloader_rejectsMismatchedContentRange(t *testing.T) {
+	archive := bytes.Repeat([]byte("0123456789"), 1024)
+
+	var ranges []string
+	attempt := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempt++
+		ranges = append(ranges, r.Header.Get("Range"))
+
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+
+		switch {
+		case attempt == 1:
+			w.Write(archive[:len(archive)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		case r.Header.Get("Range") != "":
+			// a range other than the requested one
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(archive)-1, len(archive)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(archive)
+		default:
+			w.Write(archive)
+		}
+	}))
+	defer server.Close()
+
+	a := &azureDownloader{
+		client:               server.Client(),
+		baseUrl:              server.URL,
+		downloadRetries:      2,
+		downloadRetryBackoff: time.Millisecond,
+	}
+
+	zipFilepath, err := a.downloadZipFromAzureDevOps(context.Background(), cloneOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	})
+	assert.NoError(t, err)
+	defer os.Remove(zipFilepath)
+
+	assert.Equal(t, []string{"", fmt.Sprintf("bytes=%d-", len(archive)/2), ""}, ranges)
+
+	content, err := ioutil.ReadFile(zipFilepath)
+	assert.NoError(t, err)
+	assert.Equal(t, archive, content, "the mismatched range shouldn't be appended to the partial archive")
+}
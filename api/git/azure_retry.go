@@ -0,0 +1,100 @@
+package git
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultDownloadRetryBackoff is the delay before the first download retry, doubled on each following one
+const defaultDownloadRetryBackoff = 500 * time.Millisecond
+
+// RetryPolicy tells whether a failed archive download attempt is worth retrying. resp is nil when the request failed
+// with err, and err is nil when the server answered with an unexpected status. Both are set when reading the body failed.
+type RetryPolicy func(resp *http.Response, err error) bool
+
+// DefaultRetryPolicy retries the network errors, the rate limited requests and the server errors
+func DefaultRetryPolicy(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+}
+
+// WithRetryPolicy replaces DefaultRetryPolicy by policy to decide which failed archive downloads are retried,
+// within the limit set by WithDownloadRetries. Cancelled downloads and the ones refused by an open circuit breaker
+// are never retried.
+func WithRetryPolicy(policy RetryPolicy) AzureOption {
+	return func(a *azureDownloader) {
+		a.retryPolicy = policy
+	}
+}
+
+// WithDownloadRetries retries up to maxRetries times the archive downloads failing with a network error,
+// a rate limit or a server error, or as decided by the retry policy, waiting backoff before the first retry and doubling the delay on each following one.
+// A download interrupted mid-stream resumes from the saved bytes when the server supports it,
+// and starts over otherwise. A zero backoff uses the default of 500ms.
+func WithDownloadRetries(maxRetries int, backoff time.Duration) AzureOption {
+	return func(a *azureDownloader) {
+		a.downloadRetries = maxRetries
+		a.downloadRetryBackoff = backoff
+	}
+}
+
+// downloadZipFromAzureDevOps downloads the repository archive to a temp file and returns its path,
+// retrying the transient failures as configured
+func (a *azureDownloader) downloadZipFromAzureDevOps(ctx context.Context, options cloneOptions) (string, error) {
+	backoff := a.downloadRetryBackoff
+	if backoff <= 0 {
+		backoff = defaultDownloadRetryBackoff
+	}
+
+	// the interrupted download is only resumed by the following attempts of this call, and removed when they all fail
+	var partial partialDownload
+	defer func() {
+		if partial.path != "" {
+			os.Remove(partial.path)
+		}
+	}()
+
+	for attempt := 0; ; attempt++ {
+		zipFilepath, retry, err := a.downloadZipAttempt(ctx, options, &partial)
+		if err == nil || !retry || attempt >= a.downloadRetries {
+			return zipFilepath, err
+		}
+
+		a.log().Warnf("azure archive download failed, retrying in %v (%d/%d): %v", backoff, attempt+1, a.downloadRetries, err)
+
+		select {
+		case <-ctx.Done():
+			return "", errors.Wrap(ctx.Err(), "archive download retry cancelled")
+		case <-a.clockOrDefault().After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// isTransientError returns whether a request failing with err may be worth retrying,
+// i.e. it wasn't cancelled nor refused by an open circuit breaker
+func isTransientError(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && !errors.Is(err, ErrCircuitOpen)
+}
+
+// shouldRetry returns whether the attempt which got resp or failed with err is to be retried
+// according to the retry policy
+func (a *azureDownloader) shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil && !isTransientError(ctx, err) {
+		return false
+	}
+
+	policy := a.retryPolicy
+	if policy == nil {
+		policy = DefaultRetryPolicy
+	}
+
+	return policy(resp, err)
+}
@@ -0,0 +1,178 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_WithDownloadRetries(t *testing.T) {
+	zipArchive := newZipArchive(t, map[string]string{"docker-compose.yml": "version: '3'"})
+
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(zipArchive)
+	}))
+	defer server.Close()
+
+	logger := &capturingLogger{}
+	a := NewAzureDownloader(server.Client(), WithDownloadRetries(2, time.Millisecond), WithLogger(logger))
+	a.baseUrl = server.URL
+
+	dir, err := ioutil.TempDir("", "azure-download-retries-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = a.download(context.Background(), dir, cloneOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+	assert.FileExists(t, filepath.Join(dir, "docker-compose.yml"))
+
+	var warnings []string
+	for _, line := range logger.lines {
+		if strings.HasPrefix(line, "WARN ") {
+			warnings = append(warnings, line)
+		}
+	}
+	if assert.Len(t, warnings, 1) {
+		assert.Contains(t, warnings[0], "retrying")
+	}
+}
+
+func Test_WithDownloadRetries_restartsInterruptedDownload(t *testing.T) {
+	archive := bytes.Repeat([]byte("0123456789"), 1024)
+
+	var ranges []string
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		ranges = append(ranges, r.Header.Get("Range"))
+
+		// no range support, the download can only start over
+		w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+		if attempts == 1 {
+			w.Write(archive[:len(archive)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		w.Write(archive)
+	}))
+	defer server.Close()
+
+	a := NewAzureDownloader(server.Client(), WithDownloadRetries(1, time.Millisecond))
+	a.baseUrl = server.URL
+
+	zipFilepath, err := a.downloadZipFromAzureDevOps(context.Background(), cloneOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	})
+	assert.NoError(t, err)
+	defer os.Remove(zipFilepath)
+
+	assert.Equal(t, []string{"", ""}, ranges)
+
+	content, err := ioutil.ReadFile(zipFilepath)
+	assert.NoError(t, err)
+	assert.Equal(t, archive, content)
+}
+
+func Test_WithDownloadRetries_stops(t *testing.T) {
+	tests := []struct {
+		name         string
+		statusCode   int
+		wantAttempts int
+	}{
+		{name: "client error", statusCode: http.StatusNotFound, wantAttempts: 1},
+		{name: "server error", statusCode: http.StatusBadGateway, wantAttempts: 3},
+		{name: "rate limited", statusCode: http.StatusTooManyRequests, wantAttempts: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				w.WriteHeader(tt.statusCode)
+			}))
+			defer server.Close()
+
+			a := NewAzureDownloader(server.Client(), WithDownloadRetries(2, time.Millisecond))
+			a.baseUrl = server.URL
+
+			_, err := a.downloadZipFromAzureDevOps(context.Background(), cloneOptions{
+				repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+				referenceName: "refs/heads/main",
+			})
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), fmt.Sprint(tt.statusCode))
+			assert.Equal(t, tt.wantAttempts, attempts)
+		})
+	}
+}
+
+func Test_WithRetryPolicy(t *testing.T) {
+	zipArchive := newZipArchive(t, map[string]string{"docker-compose.yml": "version: '3'"})
+
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusTeapot)
+			return
+		}
+		w.Write(zipArchive)
+	}))
+	defer server.Close()
+
+	options := cloneOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	}
+
+	t.Run("default policy", func(t *testing.T) {
+		attempts = 0
+		a := NewAzureDownloader(server.Client(), WithDownloadRetries(2, time.Millisecond))
+		a.baseUrl = server.URL
+
+		_, err := a.downloadZipFromAzureDevOps(context.Background(), options)
+		assert.Error(t, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("custom policy", func(t *testing.T) {
+		attempts = 0
+		var policyStatuses []int
+		a := NewAzureDownloader(server.Client(), WithDownloadRetries(2, time.Millisecond), WithRetryPolicy(func(resp *http.Response, err error) bool {
+			if resp != nil {
+				policyStatuses = append(policyStatuses, resp.StatusCode)
+			}
+			return DefaultRetryPolicy(resp, err) || (resp != nil && resp.StatusCode == http.StatusTeapot)
+		}))
+		a.baseUrl = server.URL
+
+		zipFilepath, err := a.downloadZipFromAzureDevOps(context.Background(), options)
+		assert.NoError(t, err)
+		os.Remove(zipFilepath)
+		assert.Equal(t, 2, attempts)
+		assert.Equal(t, []int{http.StatusTeapot}, policyStatuses)
+	})
+}
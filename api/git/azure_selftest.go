@@ -0,0 +1,84 @@
+package git
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SelfTestStep reports the outcome of one of the requests sent by selfTest
+type SelfTestStep struct {
+	Name    string
+	Success bool
+	Latency time.Duration
+	// Error is the reason of the failure, empty on success
+	Error string
+}
+
+// SelfTestReport gathers what selfTest found out about an Azure DevOps repository
+type SelfTestReport struct {
+	Steps []SelfTestStep
+	// DefaultBranch is the short name of the repository default branch, empty when it couldn't be detected
+	DefaultBranch string
+	// APIVersion is the version of the REST API used by the downloader
+	APIVersion string
+	// APIVersionSupported is false when the server rejected the API version
+	APIVersionSupported bool
+}
+
+// selfTest checks that the repository can be reached with the options credentials by getting the repository,
+// listing one of its refs and getting its root item. Every step is run and reported, the returned error
+// being the one of the first failed step.
+func (a *azureDownloader) selfTest(ctx context.Context, options fetchOptions) (SelfTestReport, error) {
+	report := SelfTestReport{
+		APIVersion:          azureAPIVersion,
+		APIVersionSupported: true,
+	}
+
+	var firstErr error
+	run := func(name string, step func() error) {
+		start := a.now()
+		err := step()
+
+		result := SelfTestStep{Name: name, Success: err == nil, Latency: a.since(start)}
+		if err != nil {
+			result.Error = err.Error()
+			if firstErr == nil {
+				firstErr = err
+			}
+			if isAPIVersionUnsupported(err) {
+				report.APIVersionSupported = false
+			}
+		}
+		report.Steps = append(report.Steps, result)
+	}
+
+	run("repository", func() error {
+		branch, err := a.defaultBranch(ctx, options)
+		report.DefaultBranch = branch
+		return err
+	})
+
+	run("refs", func() error {
+		return a.checkConnection(ctx, options)
+	})
+
+	run("rootItem", func() error {
+		_, err := a.getRootItem(ctx, options)
+		return err
+	})
+
+	return report, firstErr
+}
+
+// isAPIVersionUnsupported returns whether err reports that the server doesn't support the requested API version
+func isAPIVersionUnsupported(err error) bool {
+	var httpErr *AzureHTTPError
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+
+	return httpErr.StatusCode == http.StatusBadRequest && httpErr.TypeKey == "VssVersionOutOfRangeException"
+}
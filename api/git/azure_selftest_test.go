@@ -0,0 +1,78 @@
+package git
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_azureDownloader_selfTest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/repositories/Repository"):
+			w.Write([]byte(`{"name": "Repository", "defaultBranch": "refs/heads/main"}`))
+		case strings.HasSuffix(r.URL.Path, "/refs"):
+			assert.Equal(t, "1", r.URL.Query().Get("$top"))
+			w.Write([]byte(`{"count": 1, "value": [{"name": "refs/heads/main", "objectId": "27104ad7549d9e66685e115a497533f18024be9c"}]}`))
+		case strings.HasSuffix(r.URL.Path, "/items"):
+			w.Write([]byte(rootItemResponse))
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	a := &azureDownloader{
+		client:  server.Client(),
+		baseUrl: server.URL,
+	}
+
+	report, err := a.selfTest(context.Background(), fetchOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "main", report.DefaultBranch)
+	assert.Equal(t, "6.0", report.APIVersion)
+	assert.True(t, report.APIVersionSupported)
+
+	if assert.Len(t, report.Steps, 3) {
+		for i, name := range []string{"repository", "refs", "rootItem"} {
+			assert.Equal(t, name, report.Steps[i].Name)
+			assert.True(t, report.Steps[i].Success, "step %s failed: %s", name, report.Steps[i].Error)
+			assert.Empty(t, report.Steps[i].Error)
+			assert.Greater(t, int64(report.Steps[i].Latency), int64(0))
+		}
+	}
+}
+
+func Test_azureDownloader_SelfTest_unsupportedAPIVersion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"message": "The requested REST API version of 6.0 is out of range for this server.", "typeKey": "VssVersionOutOfRangeException"}`))
+	}))
+	defer server.Close()
+
+	a := &azureDownloader{
+		client:  server.Client(),
+		baseUrl: server.URL,
+	}
+
+	report, err := a.selfTest(context.Background(), fetchOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+	})
+	assert.Error(t, err)
+	assert.False(t, report.APIVersionSupported)
+	assert.Empty(t, report.DefaultBranch)
+
+	if assert.Len(t, report.Steps, 3) {
+		for _, step := range report.Steps {
+			assert.False(t, step.Success)
+			assert.Contains(t, step.Error, "out of range")
+		}
+	}
+}
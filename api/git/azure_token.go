@@ -0,0 +1,48 @@
+package git
+
+import (
+	"context"
+	"time"
+)
+
+// TokenSource returns an OAuth access token along with its expiry.
+// A zero expiry means that the token isn't cached and the source is invoked before every request.
+type TokenSource func(ctx context.Context) (token string, expiry time.Time, err error)
+
+// tokenRefreshMargin is how long before its expiry a cached token gets refreshed
+const tokenRefreshMargin = time.Minute
+
+// token returns the cached access token, or a fresh one from the token source
+// when there is none or it's about to expire
+func (a *azureDownloader) token(ctx context.Context) (string, error) {
+	a.tokenMu.Lock()
+	defer a.tokenMu.Unlock()
+
+	if a.cachedToken != "" && a.now().Add(tokenRefreshMargin).Before(a.tokenExpiry) {
+		a.log().Debugf("azure access token cache hit, token expires at %v", a.tokenExpiry)
+		return a.cachedToken, nil
+	}
+	a.log().Debugf("azure access token cache miss, requesting a token")
+
+	token, expiry, err := a.tokenSource(ctx)
+	if err != nil {
+		a.log().Warnf("azure token source failed: %v", err)
+		return "", err
+	}
+
+	a.cachedToken = ""
+	if !expiry.IsZero() {
+		a.cachedToken = token
+		a.tokenExpiry = expiry
+	}
+
+	return token, nil
+}
+
+// invalidateToken drops the cached access token, so that the next request obtains a fresh one
+func (a *azureDownloader) invalidateToken() {
+	a.tokenMu.Lock()
+	defer a.tokenMu.Unlock()
+
+	a.cachedToken = ""
+}
@@ -0,0 +1,53 @@
+package git
+
+import (
+	"context"
+)
+
+// Tracer starts the spans wrapping the downloader operations.
+// It mirrors the subset of the OpenTelemetry tracing API used by the downloader,
+// so that an adapter can be plugged in without the package depending on a tracing library.
+type Tracer interface {
+	// Start creates a span that is a child of the span carried by ctx, if any,
+	// and returns a context carrying the new span
+	Start(ctx context.Context, spanName string) (context.Context, Span)
+}
+
+// Span represents a traced operation
+type Span interface {
+	SetAttribute(key string, value interface{})
+	End()
+}
+
+// WithTracer records a span around each Azure operation
+func WithTracer(tracer Tracer) AzureOption {
+	return func(a *azureDownloader) {
+		a.tracer = tracer
+	}
+}
+
+type spanContextKey struct{}
+
+// startSpan starts a span when a tracer is configured, the returned span is never nil
+func (a *azureDownloader) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if a.tracer == nil {
+		return ctx, noopSpan{}
+	}
+
+	ctx, span := a.tracer.Start(ctx, name)
+	return context.WithValue(ctx, spanContextKey{}, span), span
+}
+
+// spanFromContext returns the span started by the downloader for the current operation
+func spanFromContext(ctx context.Context) Span {
+	if span, ok := ctx.Value(spanContextKey{}).(Span); ok {
+		return span
+	}
+
+	return noopSpan{}
+}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, interface{}) {}
+func (noopSpan) End()                             {}
@@ -0,0 +1,103 @@
+package git
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+type recordedSpan struct {
+	name       string
+	parent     *recordedSpan
+	attributes map[string]interface{}
+	ended      bool
+}
+
+type recordedSpanKey struct{}
+
+func (t *recordingTracer) Start(ctx context.Context, spanName string) (context.Context, Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	parent, _ := ctx.Value(recordedSpanKey{}).(*recordedSpan)
+	span := &recordedSpan{name: spanName, parent: parent, attributes: map[string]interface{}{}}
+	t.spans = append(t.spans, span)
+
+	return context.WithValue(ctx, recordedSpanKey{}, span), span
+}
+
+func (s *recordedSpan) SetAttribute(key string, value interface{}) {
+	s.attributes[key] = value
+}
+
+func (s *recordedSpan) End() {
+	s.ended = true
+}
+
+func Test_azureDownloader_tracing(t *testing.T) {
+	treeServer := newAzureTreeTestServer(t)
+	defer treeServer.Close()
+
+	tracer := &recordingTracer{}
+	a := NewAzureDownloader(treeServer.Client(), WithTracer(tracer))
+	a.baseUrl = strings.Replace(treeServer.URL, "http://", "http://username:password@", 1)
+
+	_, err := a.listTree(context.Background(), fetchOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	})
+	assert.NoError(t, err)
+
+	names := []string{}
+	for _, span := range tracer.spans {
+		names = append(names, span.name)
+		assert.True(t, span.ended, "span %s should be ended", span.name)
+	}
+	assert.Equal(t, []string{"azure.listTree", "azure.listRemote"}, names)
+
+	listTreeSpan, listRemoteSpan := tracer.spans[0], tracer.spans[1]
+	assert.Nil(t, listTreeSpan.parent)
+	assert.Equal(t, listTreeSpan, listRemoteSpan.parent)
+
+	assert.Equal(t, http.StatusOK, listRemoteSpan.attributes["http.status_code"])
+	assert.Contains(t, listRemoteSpan.attributes["http.url"], "/refs")
+	assert.NotContains(t, listRemoteSpan.attributes["http.url"], "password")
+	assert.Contains(t, listTreeSpan.attributes["http.url"], "/trees/")
+}
+
+func Test_azureDownloader_tracing_download(t *testing.T) {
+	archiveData := newZipArchive(t, map[string]string{"docker-compose.yml": "compose"})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archiveData)
+	}))
+	defer server.Close()
+
+	tracer := &recordingTracer{}
+	a := NewAzureDownloader(server.Client(), WithTracer(tracer))
+	a.baseUrl = server.URL
+
+	zipFilepath, err := a.downloadZipFromAzureDevOps(context.Background(), cloneOptions{
+		repositoryUrl: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		referenceName: "refs/heads/main",
+	})
+	assert.NoError(t, err)
+	defer os.Remove(zipFilepath)
+
+	if assert.Len(t, tracer.spans, 1) {
+		span := tracer.spans[0]
+		assert.Equal(t, "azure.download", span.name)
+		assert.Equal(t, int64(len(archiveData)), span.attributes["download.bytes"])
+		assert.Equal(t, http.StatusOK, span.attributes["http.status_code"])
+	}
+}
@@ -0,0 +1,380 @@
+package git
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/portainer/portainer/api/archive"
+)
+
+const bitbucketHost = "bitbucket.org"
+
+// isBitbucketUrl returns whether s is an HTTPS URL of a Bitbucket Cloud repository. SSH URLs are left to go-git,
+// which authenticates them with the client SSH key.
+func isBitbucketUrl(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "https" || u.Scheme == "http") && strings.EqualFold(u.Hostname(), bitbucketHost)
+}
+
+type bitbucketOptions struct {
+	workspace, repository string
+	// a user may pass credentials in a repository URL,
+	// for example https://<username>:<app password>@bitbucket.org/<workspace>/<repository>.git
+	username, password string
+}
+
+type bitbucketDownloader struct {
+	client  *http.Client
+	apiUrl  string
+	baseUrl string
+	// bearerTokens sends the credentials without username as OAuth access tokens rather than with basic auth
+	bearerTokens bool
+}
+
+// BitbucketOption configures a Bitbucket downloader
+type BitbucketOption func(*bitbucketDownloader)
+
+// WithBitbucketAccessTokens sends the credentials made of a password without username as OAuth access tokens,
+// in a Bearer authorization header. By default they're sent with basic auth, like the go-git clones do.
+func WithBitbucketAccessTokens() BitbucketOption {
+	return func(b *bitbucketDownloader) {
+		b.bearerTokens = true
+	}
+}
+
+// NewBitbucketDownloader creates a downloader fetching Bitbucket Cloud repositories
+// through the REST API and the archive endpoint rather than cloning them
+func NewBitbucketDownloader(client *http.Client, opts ...BitbucketOption) *bitbucketDownloader {
+	b := &bitbucketDownloader{
+		client:  client,
+		apiUrl:  "https://api.bitbucket.org",
+		baseUrl: "https://bitbucket.org",
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+func (b *bitbucketDownloader) download(ctx context.Context, destination string, options cloneOptions) error {
+	zipFilepath, err := b.downloadZip(ctx, options)
+	if err != nil {
+		return errors.Wrap(err, "failed to download a zip file from Bitbucket")
+	}
+	defer os.Remove(zipFilepath)
+
+	// Bitbucket archives hold the repository files in a {workspace}-{repository}-{commit} folder
+	err = archive.UnzipFileToFS(ctx, archive.OSFS{}, zipFilepath, destination, archive.ExtractOptions{StripComponents: 1})
+	if err != nil {
+		return errors.Wrap(err, "failed to unzip file")
+	}
+
+	return nil
+}
+
+func (b *bitbucketDownloader) downloadZip(ctx context.Context, options cloneOptions) (string, error) {
+	config, err := parseBitbucketUrl(options.repositoryUrl)
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to parse url")
+	}
+
+	fetch := fetchOptions{
+		repositoryUrl: options.repositoryUrl,
+		username:      options.username,
+		password:      options.password,
+		referenceName: options.referenceName,
+	}
+
+	revision := formatReferenceName(options.referenceName)
+	if revision == "" {
+		revision, err = b.mainBranch(ctx, config, fetch)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	downloadUrl := fmt.Sprintf("%s/%s/%s/get/%s.zip",
+		b.baseUrl,
+		url.PathEscape(config.workspace),
+		url.PathEscape(config.repository),
+		url.PathEscape(revision))
+
+	res, err := b.get(ctx, downloadUrl, config, fetch)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", errors.WithMessage(bitbucketStatusError(res), "failed to download zip")
+	}
+
+	zipFile, err := ioutil.TempFile("", "bitbucket-git-repo-*.zip")
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to create temp file")
+	}
+	defer zipFile.Close()
+
+	_, err = io.Copy(zipFile, res.Body)
+	if err != nil {
+		os.Remove(zipFile.Name())
+		return "", errors.WithMessage(err, "failed to save HTTP response to a file")
+	}
+
+	return zipFile.Name(), nil
+}
+
+func (b *bitbucketDownloader) latestCommitID(ctx context.Context, options fetchOptions) (string, error) {
+	config, err := parseBitbucketUrl(options.repositoryUrl)
+	if err != nil {
+		return "", errors.WithMessage(err, "failed to parse url")
+	}
+
+	revision := formatReferenceName(options.referenceName)
+	if revision == "" {
+		revision, err = b.mainBranch(ctx, config, options)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	commitsUrl := fmt.Sprintf("%s/2.0/repositories/%s/%s/commits/%s?pagelen=1",
+		b.apiUrl,
+		url.PathEscape(config.workspace),
+		url.PathEscape(config.repository),
+		url.PathEscape(revision))
+
+	var commits struct {
+		Values []struct {
+			Hash string `json:"hash"`
+		} `json:"values"`
+	}
+
+	if err := b.getJSON(ctx, commitsUrl, config, options, &commits); err != nil {
+		return "", errors.WithMessage(err, "failed to get repository commits")
+	}
+
+	if len(commits.Values) == 0 || commits.Values[0].Hash == "" {
+		return "", errors.Errorf("failed to get latest commitID in the repository")
+	}
+
+	return commits.Values[0].Hash, nil
+}
+
+// listRemote returns the sorted full names of the repository branches and tags of the options ref types
+func (b *bitbucketDownloader) listRemote(ctx context.Context, options fetchOptions) ([]string, error) {
+	config, err := parseBitbucketUrl(options.repositoryUrl)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to parse url")
+	}
+
+	refsUrl := fmt.Sprintf("%s/2.0/repositories/%s/%s/refs?pagelen=100",
+		b.apiUrl,
+		url.PathEscape(config.workspace),
+		url.PathEscape(config.repository))
+
+	refs := []string{}
+	for refsUrl != "" {
+		var page struct {
+			Values []struct {
+				Name string `json:"name"`
+				Type string `json:"type"`
+			} `json:"values"`
+			Next string `json:"next"`
+		}
+
+		if err := b.getJSON(ctx, refsUrl, config, options, &page); err != nil {
+			return nil, errors.WithMessage(err, "failed to get repository refs")
+		}
+
+		for _, ref := range page.Values {
+			var name string
+			switch ref.Type {
+			case "branch":
+				name = branchPrefix + ref.Name
+			case "tag":
+				name = tagPrefix + ref.Name
+			default:
+				continue
+			}
+
+			if options.refTypes.match(name) {
+				refs = append(refs, name)
+			}
+		}
+
+		if page.Next != "" {
+			if err := b.checkApiUrl(page.Next); err != nil {
+				return nil, err
+			}
+		}
+
+		refsUrl = page.Next
+	}
+
+	sort.Strings(refs)
+
+	return refs, nil
+}
+
+// checkApiUrl returns an error when the server supplied rawUrl isn't on the API host,
+// so that the credentials are never sent to another host
+func (b *bitbucketDownloader) checkApiUrl(rawUrl string) error {
+	apiUrl, err := url.Parse(b.apiUrl)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse Bitbucket API url")
+	}
+
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse Bitbucket next page url")
+	}
+
+	if !strings.EqualFold(u.Host, apiUrl.Host) || u.Scheme != apiUrl.Scheme {
+		return errors.Errorf("unexpected Bitbucket next page host %s", u.Host)
+	}
+
+	return nil
+}
+
+// mainBranch returns the name of the repository main branch
+func (b *bitbucketDownloader) mainBranch(ctx context.Context, config *bitbucketOptions, options fetchOptions) (string, error) {
+	repositoryUrl := fmt.Sprintf("%s/2.0/repositories/%s/%s",
+		b.apiUrl,
+		url.PathEscape(config.workspace),
+		url.PathEscape(config.repository))
+
+	var repository struct {
+		MainBranch struct {
+			Name string `json:"name"`
+		} `json:"mainbranch"`
+	}
+
+	if err := b.getJSON(ctx, repositoryUrl, config, options, &repository); err != nil {
+		return "", errors.WithMessage(err, "failed to get repository")
+	}
+
+	if repository.MainBranch.Name == "" {
+		return "", errors.Errorf("failed to get the main branch of the repository")
+	}
+
+	return repository.MainBranch.Name, nil
+}
+
+func (b *bitbucketDownloader) getJSON(ctx context.Context, rawUrl string, config *bitbucketOptions, options fetchOptions, v interface{}) error {
+	resp, err := b.get(ctx, rawUrl, config, options)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return bitbucketStatusError(resp)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.Wrap(err, "could not parse Bitbucket response")
+	}
+
+	return nil
+}
+
+// bitbucketStatusError returns the error of the unexpected status of resp, which is ErrAuthenticationFailure,
+// ErrInsufficientPermissions or ErrIncorrectRepositoryURL for the statuses telling them
+func bitbucketStatusError(resp *http.Response) error {
+	status := fmt.Sprintf("unexpected status \"%v\"", resp.Status)
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		return errors.WithMessage(ErrAuthenticationFailure, status)
+	case http.StatusForbidden:
+		return errors.WithMessage(ErrInsufficientPermissions, status)
+	case http.StatusNotFound:
+		return errors.WithMessage(ErrIncorrectRepositoryURL, status)
+	}
+
+	return errors.New(status)
+}
+
+func (b *bitbucketDownloader) get(ctx context.Context, rawUrl string, config *bitbucketOptions, options fetchOptions) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawUrl, nil)
+	if err != nil {
+		return nil, errors.WithMessage(err, "failed to create a new HTTP request")
+	}
+
+	username, password := options.username, options.password
+	if username == "" && password == "" {
+		username, password = config.username, config.password
+	}
+
+	// app passwords are used with the account username, OAuth access tokens on their own when enabled
+	if username == "" && password != "" && b.bearerTokens {
+		req.Header.Set("Authorization", "Bearer "+password)
+	} else if auth := getAuth(username, password); auth != nil {
+		req.SetBasicAuth(auth.Username, auth.Password)
+	} else if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, errors.WithMessage(redactURLError(err), "failed to make an HTTP request")
+	}
+
+	return resp, nil
+}
+
+const expectedBitbucketHttpUrl = "https://bitbucket.org/workspace/repository.git"
+const expectedBitbucketSshUrl = "git@bitbucket.org:workspace/repository.git"
+
+func parseBitbucketUrl(rawUrl string) (*bitbucketOptions, error) {
+	if strings.HasPrefix(rawUrl, "git@"+bitbucketHost+":") {
+		path := strings.Split(strings.TrimPrefix(rawUrl, "git@"+bitbucketHost+":"), "/")
+		if len(path) != 2 || path[0] == "" || path[1] == "" {
+			return nil, errors.Errorf("want url %s, got %s", expectedBitbucketSshUrl, rawUrl)
+		}
+
+		return &bitbucketOptions{
+			workspace:  path[0],
+			repository: strings.TrimSuffix(path[1], ".git"),
+		}, nil
+	}
+
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, errors.Wrap(redactURLError(err), "failed to parse HTTP url")
+	}
+
+	if !strings.EqualFold(u.Hostname(), bitbucketHost) {
+		return nil, errors.Errorf("unknown bitbucket host in url \"%s\"", redactURL(rawUrl))
+	}
+
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(path) != 2 || path[0] == "" || path[1] == "" {
+		return nil, errors.Errorf("want url %s, got %s", expectedBitbucketHttpUrl, redactURL(rawUrl))
+	}
+
+	opt := bitbucketOptions{
+		workspace:  path[0],
+		repository: strings.TrimSuffix(path[1], ".git"),
+	}
+	opt.username = u.User.Username()
+	opt.password, _ = u.User.Password()
+
+	return &opt, nil
+}
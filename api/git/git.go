@@ -5,7 +5,9 @@ import (
 	"crypto/tls"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -14,9 +16,40 @@ import (
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
 	"github.com/go-git/go-git/v5/storage/memory"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	// ErrRefNotFound is returned when the requested ref doesn't exist in the repository
+	ErrRefNotFound = errors.New("the requested ref could not be found in the repository")
+	// ErrIncorrectRepositoryURL is returned when the repository doesn't exist at the given URL
+	ErrIncorrectRepositoryURL = errors.New("git repository could not be found, please ensure that the URL is correct")
+	// ErrAuthenticationFailure is returned when the server rejects the provided credentials
+	ErrAuthenticationFailure = errors.New("authentication failed, please ensure that the git credentials are correct")
+	// ErrInsufficientPermissions is returned when valid credentials lack the scope required to read the repository
+	ErrInsufficientPermissions = errors.New("insufficient permissions, please ensure that the git credentials grant read access to the repository code")
+	// ErrRepositoryDisabled is returned when the repository exists but has been disabled by its administrators
+	ErrRepositoryDisabled = errors.New("the git repository is disabled, please ask its administrators to enable it")
+	// ErrArchiveTooLarge is returned when a repository archive exceeds the configured maximum size
+	ErrArchiveTooLarge = errors.New("the repository archive exceeds the maximum allowed size")
+	// ErrChecksumMismatch is returned when a downloaded archive doesn't match its expected checksum
+	ErrChecksumMismatch = errors.New("the repository archive doesn't match the expected checksum")
+	// StopIteration is returned by the callbacks of the listing functions to stop listing without error
+	StopIteration = errors.New("stop iteration")
+	// ErrUnexpectedResponse is returned when a JSON endpoint answers with an HTML page, usually a sign-in page
+	// or a proxy error page
+	ErrUnexpectedResponse = errors.New("the git server returned an HTML page instead of the expected response, please check the credentials and the proxy settings")
+	// ErrCircuitOpen is returned without sending the request when the recent requests to the same host kept failing
+	ErrCircuitOpen = errors.New("the git server is unavailable, requests are suspended until it recovers")
+	// ErrHostNotAllowed is returned without sending any request when the repository host isn't in the allowed hosts
+	ErrHostNotAllowed = errors.New("the repository host is not in the list of allowed hosts")
+	// ErrUnsupportedRepository is returned when the requested operation is only available for Azure DevOps repositories
+	ErrUnsupportedRepository = errors.New("the operation is only supported for Azure DevOps repositories")
 )
 
 type fetchOptions struct {
@@ -24,6 +57,26 @@ type fetchOptions struct {
 	username      string
 	password      string
 	referenceName string
+	// extensions limits listed files to the ones with the given extensions,
+	// an empty list means that all files are listed
+	extensions []string
+	// caseSensitiveExtensions enforces strict extension matching,
+	// by default .yml matches both file.yml and FILE.YML
+	caseSensitiveExtensions bool
+	// patterns limits listed files to the ones matching at least one of the given glob patterns,
+	// where ** matches any number of folders, e.g. **/docker-compose*.y*ml.
+	// When both extensions and patterns are set, a file must match both.
+	patterns []string
+	// scopePath limits the listed tree to the given repository folder, the root folder by default
+	scopePath string
+	// pathPrefix limits the listed files to the given repository folder like scopePath, but has
+	// Azure DevOps filter the items list server-side, which is cheaper for large repositories.
+	// It takes precedence over scopePath and recursionLevel.
+	pathPrefix string
+	// recursionLevel limits the depth of the listed tree, recursionLevelFull by default
+	recursionLevel recursionLevel
+	// refTypes limits the refs returned by listRemote, refTypesAll by default
+	refTypes refTypes
 }
 
 type cloneOptions struct {
@@ -32,6 +85,57 @@ type cloneOptions struct {
 	password      string
 	referenceName string
 	depth         int
+	// extensions limits extracted files to the ones with the given extensions,
+	// an empty list means that all files are extracted
+	extensions []string
+	// caseSensitiveExtensions enforces strict extension matching
+	caseSensitiveExtensions bool
+	// recurseSubmodules is how deep nested submodules are initialized after a go-git clone,
+	// git.NoRecurseSubmodules by default. Archive downloads don't include submodules.
+	recurseSubmodules git.SubmoduleRescursivity
+	// recursionLevel limits the depth of the downloaded Azure archives, recursionLevelFull by default
+	recursionLevel recursionLevel
+	// expectedSHA256 is the hex encoded SHA-256 checksum the downloaded Azure archive must match, if set
+	expectedSHA256 string
+	// scopePath restricts the Azure archive to the folder at this path, which is extracted as the destination root.
+	// The whole repository is downloaded by default.
+	scopePath string
+}
+
+// recursionLevel is how deep Azure DevOps lists or archives the repository folders
+type recursionLevel string
+
+const (
+	// recursionLevelNone only includes the requested item itself
+	recursionLevelNone recursionLevel = "none"
+	// recursionLevelOneLevel includes the direct children of the requested folder
+	recursionLevelOneLevel recursionLevel = "oneLevel"
+	// recursionLevelFull includes the whole folder hierarchy
+	recursionLevelFull recursionLevel = "full"
+)
+
+// refTypes selects the kinds of refs listed
+type refTypes string
+
+const (
+	// refTypesAll lists every ref
+	refTypesAll refTypes = ""
+	// refTypesBranches only lists the branches
+	refTypesBranches refTypes = "branches"
+	// refTypesTags only lists the tags
+	refTypesTags refTypes = "tags"
+)
+
+// match returns whether the ref named refName is of the selected kinds
+func (t refTypes) match(refName string) bool {
+	switch t {
+	case refTypesBranches:
+		return strings.HasPrefix(refName, branchPrefix)
+	case refTypesTags:
+		return strings.HasPrefix(refName, tagPrefix)
+	}
+
+	return true
 }
 
 type downloader interface {
@@ -41,13 +145,42 @@ type downloader interface {
 
 type gitClient struct {
 	preserveGitDirectory bool
+	// sshAuth authenticates the clones and fetches of SSH URLs
+	sshAuth *gitssh.PublicKeys
+	// hostKeyCallback verifies the SSH host keys, the user's known_hosts files are used when nil
+	hostKeyCallback ssh.HostKeyCallback
+	// lfs enables the resolution of the Git LFS pointer files of the clones
+	lfs bool
+	// httpClient sends the requests go-git doesn't send itself, http.DefaultClient when nil
+	httpClient *http.Client
+	// keepGlobalProtocols prevents NewService from replacing the process-wide go-git https transport
+	keepGlobalProtocols bool
+	// insecureSkipTLS and caBundle configure the TLS verification of the client calls only,
+	// go-git then uses a dedicated transport instead of the process-wide one
+	insecureSkipTLS bool
+	caBundle        []byte
+	// transport is the go-git https transport of the client set by NewService, to which the routing transport
+	// hands the client sessions. The default go-git transports are used when nil.
+	transport transport.Transport
+	// azureOptions configure the Azure DevOps downloader of the service created by NewService
+	azureOptions []AzureOption
+	// bitbucketAPI makes the service created by NewService download the Bitbucket Cloud repositories
+	// through the Bitbucket API configured by bitbucketOptions rather than clone them with go-git
+	bitbucketAPI     bool
+	bitbucketOptions []BitbucketOption
+	// allowedHosts restricts the repository hosts of the service created by NewService,
+	// any host is allowed when nil and none when empty
+	allowedHosts []string
 }
 
 func (c gitClient) download(ctx context.Context, dst string, opt cloneOptions) error {
 	gitOptions := git.CloneOptions{
-		URL:   opt.repositoryUrl,
-		Depth: opt.depth,
-		Auth:  getAuth(opt.username, opt.password),
+		URL:               opt.repositoryUrl,
+		Depth:             opt.depth,
+		Auth:              c.auth(opt.repositoryUrl, opt.username, opt.password),
+		RecurseSubmodules: opt.recurseSubmodules,
+		InsecureSkipTLS:   c.insecureSkipTLS,
+		CABundle:          c.caBundle,
 	}
 
 	if opt.referenceName != "" {
@@ -60,6 +193,12 @@ func (c gitClient) download(ctx context.Context, dst string, opt cloneOptions) e
 		return errors.Wrap(err, "failed to clone git repository")
 	}
 
+	if c.lfs {
+		if err := c.resolveLFSPointers(ctx, dst, opt); err != nil {
+			return errors.WithMessage(err, "failed to fetch Git LFS content")
+		}
+	}
+
 	if !c.preserveGitDirectory {
 		os.RemoveAll(filepath.Join(dst, ".git"))
 	}
@@ -74,7 +213,9 @@ func (c gitClient) latestCommitID(ctx context.Context, opt fetchOptions) (string
 	})
 
 	listOptions := &git.ListOptions{
-		Auth: getAuth(opt.username, opt.password),
+		Auth:            c.auth(opt.repositoryUrl, opt.username, opt.password),
+		InsecureSkipTLS: c.insecureSkipTLS,
+		CABundle:        c.caBundle,
 	}
 
 	refs, err := remote.List(listOptions)
@@ -100,6 +241,151 @@ func (c gitClient) latestCommitID(ctx context.Context, opt fetchOptions) (string
 	return "", errors.Errorf("could not find ref %q in the repository", opt.referenceName)
 }
 
+// listTree returns the sorted paths of the repository files matching the options extensions and patterns.
+// The ref is cloned in memory at depth 1 without checking out the files, so that only its tree is walked.
+func (c gitClient) listTree(ctx context.Context, opt fetchOptions) ([]string, error) {
+	gitOptions := git.CloneOptions{
+		URL:             opt.repositoryUrl,
+		Depth:           1,
+		Auth:            c.auth(opt.repositoryUrl, opt.username, opt.password),
+		Tags:            git.NoTags,
+		InsecureSkipTLS: c.insecureSkipTLS,
+		CABundle:        c.caBundle,
+	}
+
+	if opt.referenceName != "" {
+		gitOptions.ReferenceName = plumbing.ReferenceName(opt.referenceName)
+		gitOptions.SingleBranch = true
+	}
+
+	repo, err := git.CloneContext(ctx, memory.NewStorage(), nil, &gitOptions)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to clone git repository")
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve the cloned ref")
+	}
+
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get commit %s", head.Hash())
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get the tree of commit %s", head.Hash())
+	}
+
+	paths := []string{}
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if matchExtensions(f.Name, opt.extensions, opt.caseSensitiveExtensions) && matchPatterns(f.Name, opt.patterns) {
+			paths = append(paths, f.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to walk the repository tree")
+	}
+
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+// matchExtensions reports whether target ends with one of the given extensions.
+// An empty extensions list matches any target.
+func matchExtensions(target string, extensions []string, caseSensitive bool) bool {
+	if len(extensions) == 0 {
+		return true
+	}
+
+	if !caseSensitive {
+		target = strings.ToLower(target)
+	}
+
+	for _, extension := range extensions {
+		if !caseSensitive {
+			extension = strings.ToLower(extension)
+		}
+
+		if strings.HasSuffix(target, extension) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isHiddenPath reports whether one of the components of target starts with a dot, e.g. .github/workflows/ci.yml
+func isHiddenPath(target string) bool {
+	for _, component := range strings.Split(strings.Trim(target, "/"), "/") {
+		if strings.HasPrefix(component, ".") && component != "." && component != ".." {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isGitDirPath reports whether target is or is inside a .git folder, which holds the git metadata
+// rather than repository files and must never be extracted
+func isGitDirPath(target string) bool {
+	for _, component := range strings.Split(strings.Trim(target, "/"), "/") {
+		if strings.EqualFold(component, ".git") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchPatterns reports whether target matches one of the given glob patterns.
+// Patterns follow the path.Match syntax, with ** matching zero or more path segments.
+// An empty patterns list matches any target.
+func matchPatterns(target string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	segments := strings.Split(strings.TrimPrefix(target, "/"), "/")
+	for _, pattern := range patterns {
+		if matchSegments(strings.Split(strings.TrimPrefix(pattern, "/"), "/"), segments) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchSegments(pattern, segments []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(segments); i++ {
+				if matchSegments(pattern[1:], segments[i:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(segments) == 0 {
+			return false
+		}
+
+		matched, err := path.Match(pattern[0], segments[0])
+		if err != nil || !matched {
+			return false
+		}
+
+		pattern, segments = pattern[1:], segments[1:]
+	}
+
+	return len(segments) == 0
+}
+
 func getAuth(username, password string) *githttp.BasicAuth {
 	if password != "" {
 		if username == "" {
@@ -114,30 +400,89 @@ func getAuth(username, password string) *githttp.BasicAuth {
 	return nil
 }
 
+// Connection pool settings of the HTTP client shared by the downloaders
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 10
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
 // Service represents a service for managing Git.
 type Service struct {
 	httpsCli *http.Client
 	azure    downloader
-	git      downloader
+	// bitbucket downloads the Bitbucket Cloud repositories, which are cloned with go-git when nil
+	bitbucket downloader
+	git       downloader
+	// allowedHosts restricts the repository hosts, any host is allowed when nil and none when empty
+	allowedHosts []string
 }
 
 // NewService initializes a new service.
-func NewService() *Service {
+// The options configure the go-git client handling the repositories other than the Azure DevOps ones
+// and, when WithBitbucketAPI enables the Bitbucket downloader, the Bitbucket Cloud ones.
+// WithAzureOptions configures the Azure DevOps downloader.
+// Unless WithoutGlobalProtocolOverride is used, the go-git clones of the service use a dedicated transport sharing
+// the settings of the service HTTP client. As go-git only reads its transports from a process-wide registry,
+// the service installs a routing https transport there, which leaves the other go-git clones of the process
+// to the transport installed before it.
+func NewService(opts ...GitOption) *Service {
 	httpsCli := &http.Client{
 		Transport: &http.Transport{
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 			Proxy:           http.ProxyFromEnvironment,
+			// syncing many repositories hits the same few hosts repeatedly,
+			// keep more connections to each of them than the default 2
+			MaxIdleConns:        defaultMaxIdleConns,
+			MaxIdleConnsPerHost: defaultMaxIdleConnsPerHost,
+			IdleConnTimeout:     defaultIdleConnTimeout,
 		},
 		Timeout: 300 * time.Second,
 	}
 
-	client.InstallProtocol("https", githttp.NewClient(httpsCli))
+	gitClient := NewGitClient(append([]GitOption{withHTTPClient(httpsCli)}, opts...)...)
+	if !gitClient.keepGlobalProtocols {
+		installRoutingTransport()
+
+		// the dedicated transport keeps the proxy and pool settings of the service client and applies the TLS settings
+		// of the go-git client, which go-git would otherwise apply with its own transports ignoring them
+		gitClient.httpClient = newDedicatedHTTPClient(httpsCli, gitClient.insecureSkipTLS, gitClient.caBundle)
+		gitClient.transport = githttp.NewClient(gitClient.httpClient)
+		gitClient.insecureSkipTLS, gitClient.caBundle = false, nil
+	}
+
+	azureOptions := gitClient.azureOptions
+	if gitClient.allowedHosts != nil {
+		azureOptions = append([]AzureOption{WithAllowedHosts(gitClient.allowedHosts)}, azureOptions...)
+	}
+
+	service := &Service{
+		httpsCli:     httpsCli,
+		azure:        NewAzureDownloader(httpsCli, azureOptions...),
+		git:          gitClient,
+		allowedHosts: gitClient.allowedHosts,
+	}
+
+	if gitClient.bitbucketAPI {
+		service.bitbucket = NewBitbucketDownloader(httpsCli, gitClient.bitbucketOptions...)
+	}
+
+	return service
+}
+
+// checkHost returns ErrHostNotAllowed when the host of repositoryURL, either a URL or an scp-like SSH address,
+// isn't one of the allowed hosts of the service
+func (service *Service) checkHost(repositoryURL string) error {
+	if service.allowedHosts == nil {
+		return nil
+	}
 
-	return &Service{
-		httpsCli: httpsCli,
-		azure:    NewAzureDownloader(httpsCli),
-		git:      gitClient{},
+	endpoint, err := transport.NewEndpoint(repositoryURL)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse repository url")
 	}
+
+	return checkAllowedHost(service.allowedHosts, endpoint.Host)
 }
 
 // CloneRepository clones a git repository using the specified URL in the specified
@@ -155,10 +500,18 @@ func (service *Service) CloneRepository(destination, repositoryURL, referenceNam
 }
 
 func (service *Service) cloneRepository(destination string, options cloneOptions) error {
+	if err := service.checkHost(options.repositoryUrl); err != nil {
+		return err
+	}
+
 	if isAzureUrl(options.repositoryUrl) {
 		return service.azure.download(context.TODO(), destination, options)
 	}
 
+	if service.bitbucket != nil && isBitbucketUrl(options.repositoryUrl) {
+		return service.bitbucket.download(context.TODO(), destination, options)
+	}
+
 	return service.git.download(context.TODO(), destination, options)
 }
 
@@ -171,9 +524,17 @@ func (service *Service) LatestCommitID(repositoryURL, referenceName, username, p
 		referenceName: referenceName,
 	}
 
+	if err := service.checkHost(options.repositoryUrl); err != nil {
+		return "", err
+	}
+
 	if isAzureUrl(options.repositoryUrl) {
 		return service.azure.latestCommitID(context.TODO(), options)
 	}
 
+	if service.bitbucket != nil && isBitbucketUrl(options.repositoryUrl) {
+		return service.bitbucket.latestCommitID(context.TODO(), options)
+	}
+
 	return service.git.latestCommitID(context.TODO(), options)
 }
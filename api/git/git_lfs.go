@@ -0,0 +1,368 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	formatcfg "github.com/go-git/go-git/v5/plumbing/format/config"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/pkg/errors"
+)
+
+const (
+	lfsPointerVersion = "version https://git-lfs.github.com/spec/v1"
+	// lfsPointerMaxSize is the maximum size of a pointer file, larger files are never pointers
+	lfsPointerMaxSize = 1024
+	lfsMediaType      = "application/vnd.git-lfs+json"
+)
+
+// WithLFS makes the client replace the Git LFS pointer files of the clones by their content,
+// fetched from the LFS server of the repository with the clone credentials. Disabled by default.
+func WithLFS(enabled bool) GitOption {
+	return func(c *gitClient) {
+		c.lfs = enabled
+	}
+}
+
+// withHTTPClient sets the client used for the requests go-git doesn't send itself, such as the LFS ones
+func withHTTPClient(client *http.Client) GitOption {
+	return func(c *gitClient) {
+		c.httpClient = client
+	}
+}
+
+// lfsPointer is a Git LFS pointer file standing for the object with the given sha256 oid
+type lfsPointer struct {
+	path string
+	oid  string
+	size int64
+}
+
+// parseLFSPointer returns the pointer described by content, or false when content isn't an LFS pointer
+func parseLFSPointer(content []byte) (lfsPointer, bool) {
+	if !bytes.HasPrefix(content, []byte(lfsPointerVersion+"\n")) {
+		return lfsPointer{}, false
+	}
+
+	var pointer lfsPointer
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		switch key {
+		case "oid":
+			pointer.oid = strings.TrimPrefix(value, "sha256:")
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return lfsPointer{}, false
+			}
+			pointer.size = size
+		}
+	}
+
+	if len(pointer.oid) != sha256.Size*2 {
+		return lfsPointer{}, false
+	}
+
+	return pointer, true
+}
+
+// findLFSPointers returns the LFS pointer files of the worktree at dir
+func findLFSPointers(dir string) ([]lfsPointer, error) {
+	var pointers []lfsPointer
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !info.Mode().IsRegular() || info.Size() > lfsPointerMaxSize {
+			return nil
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		if pointer, ok := parseLFSPointer(content); ok {
+			pointer.path = path
+			pointers = append(pointers, pointer)
+		}
+
+		return nil
+	})
+
+	return pointers, err
+}
+
+// lfsEndpoint returns the LFS server URL of the repository cloned at dir,
+// read from the lfs.url setting of its .lfsconfig file or derived from the repository URL
+func lfsEndpoint(dir, repositoryUrl string) (string, error) {
+	if file, err := os.Open(filepath.Join(dir, ".lfsconfig")); err == nil {
+		defer file.Close()
+
+		cfg := formatcfg.New()
+		if err := formatcfg.NewDecoder(file).Decode(cfg); err != nil {
+			return "", errors.Wrap(err, "failed to parse .lfsconfig")
+		}
+
+		if endpoint := cfg.Section("lfs").Option("url"); endpoint != "" {
+			return strings.TrimSuffix(endpoint, "/"), nil
+		}
+	}
+
+	endpoint, err := transport.NewEndpoint(repositoryUrl)
+	if err != nil {
+		return "", errors.Wrap(redactURLError(err), "failed to parse repository url")
+	}
+
+	if endpoint.Protocol != "http" && endpoint.Protocol != "https" {
+		return "", errors.Errorf("Git LFS is only supported for HTTP repositories, got %s", endpoint.Protocol)
+	}
+
+	u, err := url.Parse(repositoryUrl)
+	if err != nil {
+		return "", errors.Wrap(redactURLError(err), "failed to parse repository url")
+	}
+	u.User = nil
+
+	repositoryPath := strings.TrimSuffix(u.Path, "/")
+	if !strings.HasSuffix(repositoryPath, ".git") {
+		repositoryPath += ".git"
+	}
+	u.Path = repositoryPath + "/info/lfs"
+
+	return u.String(), nil
+}
+
+type lfsBatchObject struct {
+	Oid     string `json:"oid"`
+	Size    int64  `json:"size"`
+	Actions struct {
+		Download *struct {
+			Href   string            `json:"href"`
+			Header map[string]string `json:"header"`
+		} `json:"download"`
+	} `json:"actions,omitempty"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// resolveLFSPointers replaces the LFS pointer files of the worktree at dir by their content
+func (c gitClient) resolveLFSPointers(ctx context.Context, dir string, opt cloneOptions) error {
+	pointers, err := findLFSPointers(dir)
+	if err != nil {
+		return errors.Wrap(err, "failed to look for Git LFS pointers")
+	}
+
+	if len(pointers) == 0 {
+		return nil
+	}
+
+	endpoint, err := lfsEndpoint(dir, opt.repositoryUrl)
+	if err != nil {
+		return err
+	}
+
+	objects, err := c.lfsBatch(ctx, endpoint, pointers, opt)
+	if err != nil {
+		return err
+	}
+
+	for _, pointer := range pointers {
+		object, ok := objects[pointer.oid]
+		if !ok || object.Actions.Download == nil {
+			if ok && object.Error != nil {
+				return errors.Errorf("failed to get Git LFS object %s: %s", pointer.oid, object.Error.Message)
+			}
+			return errors.Errorf("Git LFS server returned no download for object %s", pointer.oid)
+		}
+
+		if err := c.downloadLFSObject(ctx, pointer, object, opt); err != nil {
+			return errors.WithMessagef(err, "failed to download Git LFS object %s", pointer.oid)
+		}
+	}
+
+	return nil
+}
+
+// lfsBatch requests the download actions of the pointed objects, indexed by oid
+func (c gitClient) lfsBatch(ctx context.Context, endpoint string, pointers []lfsPointer, opt cloneOptions) (map[string]lfsBatchObject, error) {
+	request := struct {
+		Operation string           `json:"operation"`
+		Transfers []string         `json:"transfers"`
+		Objects   []lfsBatchObject `json:"objects"`
+	}{Operation: "download", Transfers: []string{"basic"}}
+
+	for _, pointer := range pointers {
+		request.Objects = append(request.Objects, lfsBatchObject{Oid: pointer.oid, Size: pointer.size})
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode Git LFS batch request")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/objects/batch", bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.WithMessage(redactURLError(err), "failed to create a new HTTP request")
+	}
+	req.Header.Set("Accept", lfsMediaType)
+	req.Header.Set("Content-Type", lfsMediaType)
+	setLFSAuth(req, opt)
+
+	resp, err := c.lfsHTTPClient().Do(req)
+	if err != nil {
+		return nil, errors.WithMessage(redactURLError(err), "failed to make an HTTP request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Git LFS batch request failed with a status \"%v\"", resp.Status)
+	}
+
+	var response struct {
+		Objects []lfsBatchObject `json:"objects"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, errors.Wrap(err, "could not parse Git LFS batch response")
+	}
+
+	objects := make(map[string]lfsBatchObject, len(response.Objects))
+	for _, object := range response.Objects {
+		objects[object.Oid] = object
+	}
+
+	return objects, nil
+}
+
+// downloadLFSObject replaces the pointer file by the object content, after checking its size and hash
+func (c gitClient) downloadLFSObject(ctx context.Context, pointer lfsPointer, object lfsBatchObject, opt cloneOptions) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, object.Actions.Download.Href, nil)
+	if err != nil {
+		return errors.WithMessage(redactURLError(err), "failed to create a new HTTP request")
+	}
+
+	// the headers of the batch response authenticate the download, the clone credentials are
+	// only added for a download from the repository host
+	for key, value := range object.Actions.Download.Header {
+		req.Header.Set(key, value)
+	}
+	if req.Header.Get("Authorization") == "" {
+		setLFSAuth(req, opt)
+	}
+
+	resp, err := c.lfsHTTPClient().Do(req)
+	if err != nil {
+		return errors.WithMessage(redactURLError(err), "failed to make an HTTP request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status \"%v\"", resp.Status)
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(pointer.path), ".lfs-*")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temp file")
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(file, hash), io.LimitReader(resp.Body, pointer.size+1))
+	if err != nil {
+		return errors.Wrap(err, "failed to save HTTP response to a file")
+	}
+
+	if written != pointer.size || hex.EncodeToString(hash.Sum(nil)) != pointer.oid {
+		return errors.New("downloaded content doesn't match the pointer")
+	}
+
+	if err := file.Close(); err != nil {
+		return errors.Wrap(err, "failed to save HTTP response to a file")
+	}
+
+	info, err := os.Stat(pointer.path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(file.Name(), info.Mode()); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), pointer.path)
+}
+
+func (c gitClient) lfsHTTPClient() *http.Client {
+	if c.httpClient == nil {
+		return http.DefaultClient
+	}
+
+	return c.httpClient
+}
+
+// setLFSAuth authenticates the LFS request with the clone credentials, unless the request targets another
+// scheme or host than the repository URL: both the lfs.url setting and the batch response are controlled by
+// the repository, which could otherwise have the credentials sent to any server
+func setLFSAuth(req *http.Request, opt cloneOptions) {
+	repositoryUrl, err := url.Parse(opt.repositoryUrl)
+	if err != nil || !sameOrigin(req.URL, repositoryUrl) {
+		return
+	}
+
+	if auth := getAuth(opt.username, opt.password); auth != nil {
+		req.SetBasicAuth(auth.Username, auth.Password)
+		return
+	}
+
+	if repositoryUrl.User != nil {
+		password, _ := repositoryUrl.User.Password()
+		req.SetBasicAuth(repositoryUrl.User.Username(), password)
+	}
+}
+
+// sameOrigin returns whether a and b have the same scheme, host and port, the default port of the scheme
+// being used when none is set
+func sameOrigin(a, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) &&
+		strings.EqualFold(a.Hostname(), b.Hostname()) &&
+		originPort(a) == originPort(b)
+}
+
+func originPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "http":
+		return "80"
+	case "https":
+		return "443"
+	}
+
+	return ""
+}
@@ -0,0 +1,169 @@
+package git
+
+import (
+	"net"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// GitOption configures the go-git client used for the repositories not handled by a dedicated downloader
+type GitOption func(*gitClient)
+
+// NewGitClient creates a client cloning and fetching repositories with go-git
+func NewGitClient(opts ...GitOption) *gitClient {
+	c := &gitClient{}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// WithoutGlobalProtocolOverride keeps NewService from installing its routing transport as the process-wide
+// go-git https transport. The service HTTP client then only serves the Azure DevOps, Bitbucket and LFS requests
+// of the service, while the go-git clones use the default go-git transport, which verifies the TLS certificates.
+func WithoutGlobalProtocolOverride() GitOption {
+	return func(c *gitClient) {
+		c.keepGlobalProtocols = true
+	}
+}
+
+// WithAzureOptions makes NewService configure its Azure DevOps downloader with opts, on top of the HTTP client
+// shared by the service. The options are appended to the ones of the previous WithAzureOptions calls.
+func WithAzureOptions(opts ...AzureOption) GitOption {
+	return func(c *gitClient) {
+		c.azureOptions = append(c.azureOptions, opts...)
+	}
+}
+
+// WithBitbucketAPI makes NewService download the HTTPS Bitbucket Cloud repositories through the Bitbucket API
+// and archive endpoint, configured by opts, rather than clone them with go-git like the other repositories.
+// The downloads only contain the repository files, without the .git directory nor the submodules.
+func WithBitbucketAPI(opts ...BitbucketOption) GitOption {
+	return func(c *gitClient) {
+		c.bitbucketAPI = true
+		c.bitbucketOptions = append(c.bitbucketOptions, opts...)
+	}
+}
+
+// WithAllowedGitHosts restricts the repositories the service created by NewService accesses to the ones whose host
+// is in hosts, whichever of the Azure DevOps, Bitbucket or go-git backends handles them. The entries follow the rules
+// of WithAllowedHosts: exact host names or wildcards such as *.corp.local, compared case-insensitively.
+// Other repositories fail with ErrHostNotAllowed before any request is sent.
+// Any host is allowed by default or when hosts is nil, while an empty non-nil list rejects every repository.
+func WithAllowedGitHosts(hosts []string) GitOption {
+	return func(c *gitClient) {
+		c.allowedHosts = normalizeAllowedHosts(hosts)
+	}
+}
+
+// WithInsecureSkipTLS makes the client skip the verification of the TLS certificates of the HTTPS repositories.
+// The setting only applies to the clones and fetches of this client. Within NewService, it's applied by the
+// dedicated transport of the client, which keeps the proxy and pool settings of the service; otherwise go-git uses
+// a transport of its own which ignores the proxy settings.
+func WithInsecureSkipTLS() GitOption {
+	return func(c *gitClient) {
+		c.insecureSkipTLS = true
+	}
+}
+
+// WithCABundle makes the client verify the TLS certificates of the HTTPS repositories against the system
+// certificate pool extended with the given PEM encoded certificates. Like WithInsecureSkipTLS, the setting only
+// applies to the clones and fetches of this client, and to its LFS requests within NewService.
+func WithCABundle(pemBytes []byte) GitOption {
+	return func(c *gitClient) {
+		c.caBundle = pemBytes
+	}
+}
+
+// WithSSHKey makes the client authenticate with the given PEM encoded private key when the repository URL
+// is an SSH URL. The passphrase decrypts encrypted keys and is ignored otherwise. Unless WithKnownHosts or
+// WithHostKeyCallback is used, host keys are verified against the user's known_hosts files.
+// The key is parsed when the option is created.
+func WithSSHKey(pemBytes []byte, passphrase string) (GitOption, error) {
+	auth, err := gitssh.NewPublicKeys(gitssh.DefaultUsername, pemBytes, passphrase)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse SSH private key")
+	}
+
+	return func(c *gitClient) {
+		c.sshAuth = auth
+	}, nil
+}
+
+// WithKnownHosts verifies the SSH host keys against the given known_hosts file
+// instead of the user's ones. The file is parsed when the option is created.
+func WithKnownHosts(path string) (GitOption, error) {
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to load known_hosts file %s", path)
+	}
+
+	return WithHostKeyCallback(callback), nil
+}
+
+// WithHostKeyCallback verifies the SSH host keys with callback instead of the user's known_hosts files
+func WithHostKeyCallback(callback ssh.HostKeyCallback) GitOption {
+	return func(c *gitClient) {
+		c.hostKeyCallback = callback
+	}
+}
+
+// verifyHostKey returns the configured host key verification, the user's known_hosts files by default,
+// or nil when none is available so that go-git reports the missing known_hosts
+func (c gitClient) verifyHostKey() ssh.HostKeyCallback {
+	callback := c.hostKeyCallback
+	if callback == nil {
+		var err error
+		if callback, err = gitssh.NewKnownHostsCallback(); err != nil {
+			return nil
+		}
+	}
+
+	return explainHostKeyErrors(callback)
+}
+
+// explainHostKeyErrors turns the known_hosts errors of callback into actionable messages
+func explainHostKeyErrors(callback ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			if len(keyErr.Want) > 0 {
+				return errors.Errorf("host key mismatch for %s: the %s key presented by the server doesn't match the known_hosts entry, the connection may be intercepted",
+					hostname, key.Type())
+			}
+
+			return errors.Errorf("unknown host %s: no %s key found in known_hosts", hostname, key.Type())
+		}
+
+		return err
+	}
+}
+
+// auth returns the authentication method to use for the repository at repositoryUrl
+func (c gitClient) auth(repositoryUrl, username, password string) transport.AuthMethod {
+	endpoint, err := transport.NewEndpoint(repositoryUrl)
+	if c.sshAuth != nil && err == nil && endpoint.Protocol == "ssh" {
+		// the SSH user comes from the URL, e.g. git@github.com:portainer/portainer.git
+		auth := *c.sshAuth
+		if endpoint.User != "" {
+			auth.User = endpoint.User
+		}
+		auth.HostKeyCallback = c.verifyHostKey()
+
+		return &auth
+	}
+
+	auth := getAuth(username, password)
+	if c.transport != nil && err == nil && endpoint.Protocol == "https" {
+		return &clientAuth{auth: auth, transport: c.transport}
+	}
+
+	return auth
+}
@@ -0,0 +1,466 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// newSSHKey generates a private key, PEM encoded and encrypted with passphrase when not empty
+func newSSHKey(t *testing.T, passphrase string) (ssh.PublicKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	der, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	block := &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	if passphrase != "" {
+		block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, der, []byte(passphrase), x509.PEMCipherAES256)
+		assert.NoError(t, err)
+	}
+
+	publicKey, err := ssh.NewPublicKey(&key.PublicKey)
+	assert.NoError(t, err)
+
+	return publicKey, pem.EncodeToMemory(block)
+}
+
+// newSSHGitServer starts an SSH server accepting authorizedKey and serving git-upload-pack requests
+// for the local repositories. It returns the server address and host key.
+func newSSHGitServer(t *testing.T, authorizedKey ssh.PublicKey) (string, ssh.PublicKey) {
+	if _, err := exec.LookPath("git-upload-pack"); err != nil {
+		t.Skip("git-upload-pack is not available")
+	}
+
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	assert.NoError(t, err)
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), authorizedKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown public key for %q", conn.User())
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSHGitConn(conn, config)
+		}
+	}()
+
+	return listener.Addr().String(), hostSigner.PublicKey()
+}
+
+func serveSSHGitConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+
+		go func() {
+			defer channel.Close()
+
+			for req := range requests {
+				if req.Type != "exec" || len(req.Payload) < 4 {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+
+				command := string(req.Payload[4 : 4+binary.BigEndian.Uint32(req.Payload)])
+				args := strings.SplitN(command, " ", 2)
+				if len(args) != 2 || args[0] != "git-upload-pack" {
+					channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{1}))
+					return
+				}
+
+				cmd := exec.Command(args[0], strings.Trim(args[1], "'"))
+				cmd.Stdin = channel
+				cmd.Stderr = channel.Stderr()
+				stdout, _ := cmd.StdoutPipe()
+
+				status := uint32(0)
+				if err := cmd.Start(); err != nil {
+					status = 1
+				} else {
+					io.Copy(channel, stdout)
+					if err := cmd.Wait(); err != nil {
+						status = 1
+					}
+				}
+
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				return
+			}
+		}()
+	}
+}
+
+// writeKnownHosts writes a known_hosts file trusting hostKey for addr
+func writeKnownHosts(t *testing.T, dir, addr string, hostKey ssh.PublicKey) string {
+	knownHostsPath := filepath.Join(dir, "known_hosts")
+	err := ioutil.WriteFile(knownHostsPath, []byte(knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey)+"\n"), 0600)
+	assert.NoError(t, err)
+
+	return knownHostsPath
+}
+
+func Test_WithSSHKey_clone(t *testing.T) {
+	tests := []struct {
+		name       string
+		passphrase string
+	}{
+		{name: "unencrypted key"},
+		{name: "encrypted key", passphrase: "passphrase"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publicKey, pemBytes := newSSHKey(t, tt.passphrase)
+			addr, hostKey := newSSHGitServer(t, publicKey)
+
+			dir, err := ioutil.TempDir("", "git-ssh-")
+			assert.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			t.Setenv("SSH_KNOWN_HOSTS", writeKnownHosts(t, dir, addr, hostKey))
+
+			opt, err := WithSSHKey(pemBytes, tt.passphrase)
+			assert.NoError(t, err)
+
+			service := Service{git: NewGitClient(opt)}
+			repositoryUrl := fmt.Sprintf("ssh://git@%s%s", addr, bareRepoDir)
+			destination := filepath.Join(dir, "clone")
+
+			err = service.cloneRepository(destination, cloneOptions{
+				repositoryUrl: repositoryUrl,
+				referenceName: "refs/heads/main",
+				depth:         1,
+			})
+			assert.NoError(t, err)
+			assert.FileExists(t, filepath.Join(destination, "docker-compose.yml"))
+
+			id, err := service.git.latestCommitID(context.Background(), fetchOptions{
+				repositoryUrl: repositoryUrl,
+				referenceName: "refs/heads/main",
+			})
+			assert.NoError(t, err)
+			assert.NotEmpty(t, id)
+		})
+	}
+}
+
+func Test_WithSSHKey_rejectsUnknownKey(t *testing.T) {
+	authorizedKey, _ := newSSHKey(t, "")
+	_, pemBytes := newSSHKey(t, "")
+	addr, hostKey := newSSHGitServer(t, authorizedKey)
+
+	dir, err := ioutil.TempDir("", "git-ssh-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	t.Setenv("SSH_KNOWN_HOSTS", writeKnownHosts(t, dir, addr, hostKey))
+
+	opt, err := WithSSHKey(pemBytes, "")
+	assert.NoError(t, err)
+
+	_, err = NewGitClient(opt).latestCommitID(context.Background(), fetchOptions{
+		repositoryUrl: fmt.Sprintf("ssh://git@%s%s", addr, bareRepoDir),
+	})
+	assert.Error(t, err)
+}
+
+func Test_WithSSHKey_invalidKey(t *testing.T) {
+	_, pemBytes := newSSHKey(t, "passphrase")
+
+	_, err := WithSSHKey(pemBytes, "wrong passphrase")
+	assert.Error(t, err)
+
+	_, err = WithSSHKey([]byte("not a key"), "")
+	assert.Error(t, err)
+}
+
+func Test_WithKnownHosts(t *testing.T) {
+	publicKey, pemBytes := newSSHKey(t, "")
+	addr, hostKey := newSSHGitServer(t, publicKey)
+	otherHostKey, _ := newSSHKey(t, "")
+
+	tests := []struct {
+		name        string
+		trustedKey  ssh.PublicKey
+		expectedErr string
+	}{
+		{name: "matching host key", trustedKey: hostKey},
+		{name: "mismatching host key", trustedKey: otherHostKey, expectedErr: "host key mismatch"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "git-ssh-")
+			assert.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			// the user's known_hosts must be ignored
+			t.Setenv("SSH_KNOWN_HOSTS", writeKnownHosts(t, dir, addr, hostKey))
+
+			knownHostsDir := filepath.Join(dir, "custom")
+			assert.NoError(t, os.Mkdir(knownHostsDir, 0700))
+			knownHostsOpt, err := WithKnownHosts(writeKnownHosts(t, knownHostsDir, addr, tt.trustedKey))
+			assert.NoError(t, err)
+
+			keyOpt, err := WithSSHKey(pemBytes, "")
+			assert.NoError(t, err)
+
+			_, err = NewGitClient(keyOpt, knownHostsOpt).latestCommitID(context.Background(), fetchOptions{
+				repositoryUrl: fmt.Sprintf("ssh://git@%s%s", addr, bareRepoDir),
+				referenceName: "refs/heads/main",
+			})
+			if tt.expectedErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+
+	_, err := WithKnownHosts(filepath.Join(os.TempDir(), "missing-known-hosts"))
+	assert.Error(t, err)
+}
+
+func Test_WithHostKeyCallback(t *testing.T) {
+	publicKey, pemBytes := newSSHKey(t, "")
+	addr, hostKey := newSSHGitServer(t, publicKey)
+
+	keyOpt, err := WithSSHKey(pemBytes, "")
+	assert.NoError(t, err)
+
+	var presentedKey ssh.PublicKey
+	client := NewGitClient(keyOpt, WithHostKeyCallback(func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		presentedKey = key
+		return nil
+	}))
+
+	_, err = client.latestCommitID(context.Background(), fetchOptions{
+		repositoryUrl: fmt.Sprintf("ssh://git@%s%s", addr, bareRepoDir),
+		referenceName: "refs/heads/main",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, hostKey.Marshal(), presentedKey.Marshal())
+}
+
+func Test_WithoutGlobalProtocolOverride(t *testing.T) {
+	defer client.InstallProtocol("https", client.Protocols["https"])
+
+	original := githttp.NewClient(nil)
+	client.InstallProtocol("https", original)
+
+	NewService(WithoutGlobalProtocolOverride())
+	assert.True(t, client.Protocols["https"] == original, "the go-git https transport should be unchanged")
+
+	NewService()
+	routing, ok := client.Protocols["https"].(*routingTransport)
+	if assert.True(t, ok, "the go-git https transport should be replaced by default") {
+		assert.True(t, routing.fallback == original, "the other go-git clones should keep the previous transport")
+	}
+
+	NewService()
+	assert.True(t, client.Protocols["https"] == routing, "the routing transport should be installed once")
+}
+
+func Test_WithInsecureSkipTLS_WithCABundle(t *testing.T) {
+	original := client.Protocols["https"]
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	_, otherCert := newTestCertificate(t, "other")
+	otherCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherCert.Raw})
+
+	tests := []struct {
+		name          string
+		client        *gitClient
+		wantTLSFailed bool
+	}{
+		{name: "insecure", client: NewGitClient(WithInsecureSkipTLS())},
+		{name: "trusted CA", client: NewGitClient(WithCABundle(serverCA))},
+		{name: "other CA", client: NewGitClient(WithCABundle(otherCA)), wantTLSFailed: true},
+	}
+
+	// the clients run concurrently, each with its own TLS settings
+	errs := make([][]error, len(tests))
+	var wg sync.WaitGroup
+	for i, tt := range tests {
+		wg.Add(1)
+		go func(i int, c *gitClient) {
+			defer wg.Done()
+			for j := 0; j < 3; j++ {
+				_, err := c.latestCommitID(context.Background(), fetchOptions{
+					repositoryUrl: server.URL + "/repository.git",
+					referenceName: "refs/heads/main",
+				})
+				errs[i] = append(errs[i], err)
+			}
+		}(i, tt.client)
+	}
+	wg.Wait()
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, err := range errs[i] {
+				if !assert.Error(t, err) {
+					continue
+				}
+				if tt.wantTLSFailed {
+					assert.Contains(t, err.Error(), "certificate")
+				} else {
+					assert.NotContains(t, err.Error(), "certificate")
+				}
+			}
+		})
+	}
+
+	assert.True(t, client.Protocols["https"] == original, "the go-git https transport should be unchanged")
+}
+
+func Test_WithAzureOptions(t *testing.T) {
+	service := NewService(WithAzureOptions(WithUserAgent("portainer-test"), WithMaxFiles(3)), WithAzureOptions(WithMaxArchiveBytes(10)))
+
+	azure, ok := service.azure.(*azureDownloader)
+	assert.True(t, ok)
+	assert.Equal(t, "portainer-test", azure.userAgent)
+	assert.Equal(t, 3, azure.maxFiles)
+	assert.Equal(t, int64(10), azure.maxArchiveBytes)
+}
+
+func Test_WithAllowedGitHosts(t *testing.T) {
+	service := NewService(WithoutGlobalProtocolOverride(), WithAllowedGitHosts([]string{"GitLab.corp.local", "*.azure.com"}))
+
+	dir, err := ioutil.TempDir("", "git-allowed-hosts-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, repositoryUrl := range []string{
+		"https://github.com/portainer/portainer.git",
+		"https://bitbucket.org/portainer/portainer.git",
+		"git@github.com:portainer/portainer.git",
+		"https://Organisation@visualstudio.com/Project/_git/Repository",
+	} {
+		err := service.CloneRepository(filepath.Join(dir, "clone"), repositoryUrl, "", "", "")
+		assert.ErrorIs(t, err, ErrHostNotAllowed, repositoryUrl)
+
+		_, err = service.LatestCommitID(repositoryUrl, "", "", "")
+		assert.ErrorIs(t, err, ErrHostNotAllowed, repositoryUrl)
+	}
+
+	_, err = service.GetFile(context.Background(), FetchOptions{RepositoryURL: "https://organisation.visualstudio.com/Project/_git/Repository"}, "docker-compose.yml")
+	assert.ErrorIs(t, err, ErrHostNotAllowed)
+
+	assert.NoError(t, service.checkHost("https://gitlab.corp.local/portainer/portainer.git"))
+	assert.NoError(t, service.checkHost("git@gitlab.corp.local:portainer/portainer.git"))
+	assert.NoError(t, service.checkHost("https://dev.azure.com/Organisation/Project/_git/Repository"))
+
+	azure := service.azure.(*azureDownloader)
+	assert.NoError(t, azure.checkHost("dev.azure.com"))
+	assert.ErrorIs(t, azure.checkHost("github.com"), ErrHostNotAllowed)
+}
+
+func Test_WithBitbucketAPI(t *testing.T) {
+	service := NewService(WithoutGlobalProtocolOverride())
+	assert.Nil(t, service.bitbucket, "Bitbucket repositories should be cloned with go-git by default")
+
+	service = NewService(WithoutGlobalProtocolOverride(), WithBitbucketAPI(WithBitbucketAccessTokens()))
+	bitbucket, ok := service.bitbucket.(*bitbucketDownloader)
+	assert.True(t, ok)
+	assert.True(t, bitbucket.bearerTokens)
+}
+
+func Test_NewService_dedicatedTransport(t *testing.T) {
+	defer client.InstallProtocol("https", client.Protocols["https"])
+
+	var requests int
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	serverCA := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	service := NewService(WithCABundle(serverCA))
+	gitClient := service.git.(*gitClient)
+
+	httpTransport := gitClient.httpClient.Transport.(*http.Transport)
+	assert.NotNil(t, httpTransport.Proxy, "the dedicated transport should keep the proxy settings")
+	assert.Equal(t, defaultMaxIdleConnsPerHost, httpTransport.MaxIdleConnsPerHost)
+	assert.False(t, httpTransport == service.httpsCli.Transport, "the service transport should be cloned")
+	assert.False(t, httpTransport.TLSClientConfig.InsecureSkipVerify)
+
+	_, err := service.LatestCommitID(server.URL+"/repository.git", "refs/heads/main", "", "")
+	if assert.Error(t, err) {
+		assert.NotContains(t, err.Error(), "certificate", "the dedicated transport should trust the CA bundle")
+	}
+	assert.NotZero(t, requests)
+
+	// the other go-git clones keep verifying the certificates against the system pool
+	_, err = NewGitClient().latestCommitID(context.Background(), fetchOptions{
+		repositoryUrl: server.URL + "/repository.git",
+		referenceName: "refs/heads/main",
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "certificate")
+	}
+}
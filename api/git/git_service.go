@@ -0,0 +1,338 @@
+package git
+
+import (
+	"context"
+	"io"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/portainer/portainer/api/archive"
+)
+
+// FetchOptions describe the repository, ref and files the Service listing and fetching methods operate on
+type FetchOptions struct {
+	RepositoryURL string
+	Username      string
+	Password      string
+	ReferenceName string
+	// Extensions limits the files to the ones with the given extensions, an empty list meaning all files
+	Extensions []string
+	// CaseSensitiveExtensions enforces strict extension matching, by default .yml matches both file.yml and FILE.YML
+	CaseSensitiveExtensions bool
+	// Patterns limits the files to the ones matching at least one of the given glob patterns,
+	// where ** matches any number of folders. When both Extensions and Patterns are set, a file must match both.
+	Patterns []string
+	// ScopePath limits the listed tree to the given repository folder, the root folder by default
+	ScopePath string
+	// PathPrefix limits the listed files to the given repository folder like ScopePath, but filters them server-side.
+	// It takes precedence over ScopePath and RecursionLevel.
+	PathPrefix string
+	// RecursionLevel limits the depth of the listed tree: "none", "oneLevel" or "full", the default
+	RecursionLevel string
+	// RefTypes limits the listed refs: "branches", "tags" or every ref by default
+	RefTypes string
+}
+
+func (o FetchOptions) fetchOptions() fetchOptions {
+	return fetchOptions{
+		repositoryUrl:           o.RepositoryURL,
+		username:                o.Username,
+		password:                o.Password,
+		referenceName:           o.ReferenceName,
+		extensions:              o.Extensions,
+		caseSensitiveExtensions: o.CaseSensitiveExtensions,
+		patterns:                o.Patterns,
+		scopePath:               o.ScopePath,
+		pathPrefix:              o.PathPrefix,
+		recursionLevel:          recursionLevel(o.RecursionLevel),
+		refTypes:                refTypes(o.RefTypes),
+	}
+}
+
+// CloneOptions describe the repository, ref and files the Service download methods operate on
+type CloneOptions struct {
+	RepositoryURL string
+	Username      string
+	Password      string
+	ReferenceName string
+	// Depth limits the go-git clones to the given number of commits, 0 meaning the whole history
+	Depth int
+	// Extensions limits the extracted files to the ones with the given extensions, an empty list meaning all files
+	Extensions []string
+	// CaseSensitiveExtensions enforces strict extension matching
+	CaseSensitiveExtensions bool
+	// RecurseSubmodules is how deep nested submodules are initialized after a go-git clone, none by default
+	RecurseSubmodules git.SubmoduleRescursivity
+	// RecursionLevel limits the depth of the downloaded Azure archives: "none", "oneLevel" or "full", the default
+	RecursionLevel string
+	// ExpectedSHA256 is the hex encoded SHA-256 checksum the downloaded Azure archive must match, if set
+	ExpectedSHA256 string
+	// ScopePath restricts the Azure archive to the folder at this path, which is extracted as the destination root
+	ScopePath string
+}
+
+func (o CloneOptions) cloneOptions() cloneOptions {
+	return cloneOptions{
+		repositoryUrl:           o.RepositoryURL,
+		username:                o.Username,
+		password:                o.Password,
+		referenceName:           o.ReferenceName,
+		depth:                   o.Depth,
+		extensions:              o.Extensions,
+		caseSensitiveExtensions: o.CaseSensitiveExtensions,
+		recurseSubmodules:       o.RecurseSubmodules,
+		recursionLevel:          recursionLevel(o.RecursionLevel),
+		expectedSHA256:          o.ExpectedSHA256,
+		scopePath:               o.ScopePath,
+	}
+}
+
+// azureDownloader returns the Azure DevOps downloader of the service,
+// failing with ErrUnsupportedRepository when repositoryURL isn't an Azure DevOps repository
+func (service *Service) azureDownloader(repositoryURL string) (*azureDownloader, error) {
+	if err := service.checkHost(repositoryURL); err != nil {
+		return nil, err
+	}
+
+	azure, ok := service.azure.(*azureDownloader)
+	if !ok || !isAzureUrl(repositoryURL) {
+		return nil, ErrUnsupportedRepository
+	}
+
+	return azure, nil
+}
+
+// GetFile returns the content of a single repository file without downloading the whole repository
+func (service *Service) GetFile(ctx context.Context, options FetchOptions, filePath string) ([]byte, error) {
+	azure, err := service.azureDownloader(options.RepositoryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return azure.getFile(ctx, options.fetchOptions(), filePath)
+}
+
+// GetFiles returns the content of several repository files, fetched in parallel. The files that
+// couldn't be fetched are missing from the returned contents and reported together by the error.
+func (service *Service) GetFiles(ctx context.Context, options FetchOptions, filePaths []string) (map[string][]byte, error) {
+	azure, err := service.azureDownloader(options.RepositoryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return azure.getFiles(ctx, options.fetchOptions(), filePaths)
+}
+
+// GetItemMetadata returns the metadata of the file or folder at itemPath without downloading its content
+func (service *Service) GetItemMetadata(ctx context.Context, options FetchOptions, itemPath string) (*ItemMeta, error) {
+	azure, err := service.azureDownloader(options.RepositoryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return azure.getItemMetadata(ctx, options.fetchOptions(), itemPath)
+}
+
+// PathExists returns whether a file or folder exists at itemPath at the options ref, without downloading its content
+func (service *Service) PathExists(ctx context.Context, options FetchOptions, itemPath string) (bool, error) {
+	azure, err := service.azureDownloader(options.RepositoryURL)
+	if err != nil {
+		return false, err
+	}
+
+	return azure.pathExists(ctx, options.fetchOptions(), itemPath)
+}
+
+// CheckConnection verifies that the repository exists and is reachable with the given credentials
+func (service *Service) CheckConnection(ctx context.Context, options FetchOptions) error {
+	azure, err := service.azureDownloader(options.RepositoryURL)
+	if err != nil {
+		return err
+	}
+
+	return azure.checkConnection(ctx, options.fetchOptions())
+}
+
+// SelfTest checks step by step that the repository can be reached with the options credentials
+func (service *Service) SelfTest(ctx context.Context, options FetchOptions) (SelfTestReport, error) {
+	azure, err := service.azureDownloader(options.RepositoryURL)
+	if err != nil {
+		return SelfTestReport{}, err
+	}
+
+	return azure.selfTest(ctx, options.fetchOptions())
+}
+
+// DefaultBranch returns the short name of the repository default branch,
+// or an empty string when the repository has no branch yet
+func (service *Service) DefaultBranch(ctx context.Context, options FetchOptions) (string, error) {
+	azure, err := service.azureDownloader(options.RepositoryURL)
+	if err != nil {
+		return "", err
+	}
+
+	return azure.defaultBranch(ctx, options.fetchOptions())
+}
+
+// ResolveRef resolves the options ref to its full name and the id of the commit it currently points to
+func (service *Service) ResolveRef(ctx context.Context, options FetchOptions) (fullRefName, commitID string, err error) {
+	azure, err := service.azureDownloader(options.RepositoryURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	return azure.resolveRef(ctx, options.fetchOptions())
+}
+
+// CommitsSince returns the commits of the options ref that aren't reachable from sinceCommit, newest first
+func (service *Service) CommitsSince(ctx context.Context, options FetchOptions, sinceCommit string) ([]Commit, error) {
+	azure, err := service.azureDownloader(options.RepositoryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return azure.commitsSince(ctx, options.fetchOptions(), sinceCommit)
+}
+
+// ListTreeDetailed returns the repository files matching the options filters along with their object ids and sizes,
+// sorted by path
+func (service *Service) ListTreeDetailed(ctx context.Context, options FetchOptions) ([]TreeEntry, error) {
+	azure, err := service.azureDownloader(options.RepositoryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return azure.listTreeDetailed(ctx, options.fetchOptions())
+}
+
+// ListTreeFunc calls fn with the relative path of each repository file matching the options filters.
+// Listing stops at the first error returned by fn, StopIteration stops it without error.
+func (service *Service) ListTreeFunc(ctx context.Context, options FetchOptions, fn func(path string) error) error {
+	azure, err := service.azureDownloader(options.RepositoryURL)
+	if err != nil {
+		return err
+	}
+
+	return azure.listTreeFunc(ctx, options.fetchOptions(), fn)
+}
+
+// ListTreesForRefs lists the trees of several refs concurrently, returning the sorted paths of the files
+// matching the options filters per ref, along with an error describing the refs which failed to be listed
+func (service *Service) ListTreesForRefs(ctx context.Context, options FetchOptions, refs []string) (map[string][]string, error) {
+	azure, err := service.azureDownloader(options.RepositoryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return azure.listTreesForRefs(ctx, options.fetchOptions(), refs)
+}
+
+// StateFingerprint returns a hash which changes whenever the options ref moves or a file matching the options filters changes
+func (service *Service) StateFingerprint(ctx context.Context, options FetchOptions) (string, error) {
+	azure, err := service.azureDownloader(options.RepositoryURL)
+	if err != nil {
+		return "", err
+	}
+
+	return azure.stateFingerprint(ctx, options.fetchOptions())
+}
+
+// PlanRequests returns the credential-redacted URLs that would be requested to list and download the repository,
+// without sending any request
+func (service *Service) PlanRequests(options FetchOptions) ([]string, error) {
+	azure, err := service.azureDownloader(options.RepositoryURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return azure.planRequests(options.fetchOptions())
+}
+
+// DownloadFiltered extracts only the repository files matching the options extensions into destination
+func (service *Service) DownloadFiltered(ctx context.Context, destination string, options CloneOptions) error {
+	azure, err := service.azureDownloader(options.RepositoryURL)
+	if err != nil {
+		return err
+	}
+
+	return azure.downloadFiltered(ctx, destination, options.cloneOptions())
+}
+
+// DownloadResolved resolves the options ref to a commit id, then extracts the repository at that commit into destination
+func (service *Service) DownloadResolved(ctx context.Context, destination string, options CloneOptions) (commitID string, err error) {
+	azure, err := service.azureDownloader(options.RepositoryURL)
+	if err != nil {
+		return "", err
+	}
+
+	return azure.downloadResolved(ctx, destination, options.cloneOptions())
+}
+
+// DownloadWithCleanup extracts the repository into destination, leaving destination as it found it on failure
+func (service *Service) DownloadWithCleanup(ctx context.Context, destination string, options CloneOptions) error {
+	azure, err := service.azureDownloader(options.RepositoryURL)
+	if err != nil {
+		return err
+	}
+
+	return azure.downloadWithCleanup(ctx, destination, options.cloneOptions())
+}
+
+// DownloadToFS extracts the repository into the destination folder of the fsys filesystem
+func (service *Service) DownloadToFS(ctx context.Context, fsys archive.WritableFS, destination string, options CloneOptions) error {
+	azure, err := service.azureDownloader(options.RepositoryURL)
+	if err != nil {
+		return err
+	}
+
+	return azure.downloadToFS(ctx, fsys, destination, options.cloneOptions())
+}
+
+// DownloadArchive streams the zip archive of the repository to w, without saving nor extracting it
+func (service *Service) DownloadArchive(ctx context.Context, w io.Writer, options CloneOptions) error {
+	azure, err := service.azureDownloader(options.RepositoryURL)
+	if err != nil {
+		return err
+	}
+
+	return azure.downloadArchive(ctx, w, options.cloneOptions())
+}
+
+// DownloadBlobs streams to w a zip archive of the blobs with the given object ids, named after the object ids
+func (service *Service) DownloadBlobs(ctx context.Context, options FetchOptions, objectIds []string, w io.Writer) error {
+	azure, err := service.azureDownloader(options.RepositoryURL)
+	if err != nil {
+		return err
+	}
+
+	return azure.downloadBlobs(ctx, options.fetchOptions(), objectIds, w)
+}
+
+// ResolveProject returns the name of the Azure DevOps project of the repository named repository in the organisation
+func (service *Service) ResolveProject(ctx context.Context, organisation, repository, username, password string) (string, error) {
+	azure, ok := service.azure.(*azureDownloader)
+	if !ok {
+		return "", ErrUnsupportedRepository
+	}
+
+	return azure.ResolveProject(ctx, organisation, repository, username, password)
+}
+
+// RateLimitStatus returns the latest rate limit budget observed on the Azure DevOps responses
+func (service *Service) RateLimitStatus() RateLimit {
+	azure, ok := service.azure.(*azureDownloader)
+	if !ok {
+		return RateLimit{}
+	}
+
+	return azure.RateLimitStatus()
+}
+
+// MetricsSnapshot returns the number and cumulative duration of the Azure DevOps requests sent so far, per operation
+func (service *Service) MetricsSnapshot() map[string]OperationMetrics {
+	azure, ok := service.azure.(*azureDownloader)
+	if !ok {
+		return nil
+	}
+
+	return azure.MetricsSnapshot()
+}
@@ -0,0 +1,45 @@
+package git
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestService_GetFile(t *testing.T) {
+	var requestedPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestedPath = r.URL.Query().Get("path")
+		w.Write([]byte("version: \"3\""))
+	}))
+	defer server.Close()
+
+	service := NewService(WithoutGlobalProtocolOverride(), WithAzureOptions(func(a *azureDownloader) {
+		a.client = server.Client()
+		a.baseUrl = server.URL
+	}))
+
+	got, err := service.GetFile(context.Background(), FetchOptions{
+		RepositoryURL: "https://dev.azure.com/Organisation/Project/_git/Repository",
+		ReferenceName: "refs/heads/main",
+	}, "docker-compose.yml")
+	assert.NoError(t, err)
+	assert.Equal(t, "version: \"3\"", string(got))
+	assert.Equal(t, "/docker-compose.yml", requestedPath)
+}
+
+func TestService_unsupportedRepository(t *testing.T) {
+	service := NewService(WithoutGlobalProtocolOverride())
+
+	_, err := service.GetFile(context.Background(), FetchOptions{RepositoryURL: "https://github.com/portainer/portainer.git"}, "docker-compose.yml")
+	assert.ErrorIs(t, err, ErrUnsupportedRepository)
+
+	_, err = service.ListTreeDetailed(context.Background(), FetchOptions{RepositoryURL: "https://bitbucket.org/portainer/portainer.git"})
+	assert.ErrorIs(t, err, ErrUnsupportedRepository)
+
+	err = service.DownloadArchive(context.Background(), nil, CloneOptions{RepositoryURL: "https://github.com/portainer/portainer.git"})
+	assert.ErrorIs(t, err, ErrUnsupportedRepository)
+}
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"testing"
 
@@ -120,6 +121,115 @@ func Test_latestCommitID(t *testing.T) {
 	assert.Equal(t, "68dcaa7bd452494043c64252ab90db0f98ecf8d2", id)
 }
 
+func Test_gitClient_listTree(t *testing.T) {
+	client := gitClient{}
+
+	tests := []struct {
+		name    string
+		options fetchOptions
+		want    []string
+	}{
+		{
+			name:    "all files",
+			options: fetchOptions{repositoryUrl: bareRepoDir, referenceName: "refs/heads/main"},
+			want:    []string{"docker-compose.yml", "package.json"},
+		},
+		{
+			name:    "extensions",
+			options: fetchOptions{repositoryUrl: bareRepoDir, referenceName: "refs/heads/main", extensions: []string{".YML"}},
+			want:    []string{"docker-compose.yml"},
+		},
+		{
+			name:    "patterns",
+			options: fetchOptions{repositoryUrl: bareRepoDir, referenceName: "refs/heads/main", patterns: []string{"**/*.json"}},
+			want:    []string{"package.json"},
+		},
+		{
+			name:    "no match",
+			options: fetchOptions{repositoryUrl: bareRepoDir, referenceName: "refs/heads/main", extensions: []string{".env"}},
+			want:    []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths, err := client.listTree(context.Background(), tt.options)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, paths)
+		})
+	}
+}
+
+func Test_cloneRepository_submodules(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not available")
+	}
+
+	dir, err := ioutil.TempDir("", "git-submodules-")
+	if err != nil {
+		t.Fatalf("failed to create a temp dir")
+	}
+	defer os.RemoveAll(dir)
+
+	submoduleDir := filepath.Join(dir, "submodule")
+	repositoryDir := filepath.Join(dir, "repository")
+	for _, repoDir := range []string{submoduleDir, repositoryDir} {
+		assert.NoError(t, os.Mkdir(repoDir, 0755))
+		runGit(t, repoDir, "init", "--initial-branch=main")
+	}
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(submoduleDir, "web.yml"), []byte("version: '3'"), 0644))
+	runGit(t, submoduleDir, "add", "web.yml")
+	runGit(t, submoduleDir, "commit", "-m", "add web stack")
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(repositoryDir, "docker-compose.yml"), []byte("version: '3'"), 0644))
+	runGit(t, repositoryDir, "add", "docker-compose.yml")
+	runGit(t, repositoryDir, "submodule", "add", submoduleDir, "stacks")
+	runGit(t, repositoryDir, "commit", "-m", "add stacks submodule")
+
+	service := Service{git: gitClient{preserveGitDirectory: false}}
+
+	tests := []struct {
+		name              string
+		recurseSubmodules git.SubmoduleRescursivity
+		expectSubmodule   bool
+	}{
+		{name: "without submodules", recurseSubmodules: git.NoRecurseSubmodules},
+		{name: "with submodules", recurseSubmodules: git.DefaultSubmoduleRecursionDepth, expectSubmodule: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			destination, err := ioutil.TempDir(dir, "clone-")
+			assert.NoError(t, err)
+
+			err = service.cloneRepository(destination, cloneOptions{
+				repositoryUrl:     repositoryDir,
+				referenceName:     "refs/heads/main",
+				recurseSubmodules: tt.recurseSubmodules,
+			})
+			assert.NoError(t, err)
+			assert.FileExists(t, filepath.Join(destination, "docker-compose.yml"))
+
+			if tt.expectSubmodule {
+				assert.FileExists(t, filepath.Join(destination, "stacks", "web.yml"))
+			} else {
+				assert.NoFileExists(t, filepath.Join(destination, "stacks", "web.yml"))
+			}
+		})
+	}
+}
+
+// runGit runs a git command in workDir with a test identity
+func runGit(t *testing.T, workDir string, args ...string) {
+	args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com", "-c", "protocol.file.allow=always"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = workDir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v failed with error %v: %s", args, err, output)
+	}
+}
+
 func getCommitHistoryLength(t *testing.T, err error, dir string) int {
 	repo, err := git.PlainOpen(dir)
 	if err != nil {
@@ -190,3 +300,125 @@ func Test_cloneRepository_azure(t *testing.T) {
 		})
 	}
 }
+
+func Test_matchExtensions(t *testing.T) {
+	tests := []struct {
+		name          string
+		target        string
+		extensions    []string
+		caseSensitive bool
+		want          bool
+	}{
+		{
+			name:       "empty extensions match any file",
+			target:     "docker-compose.yml",
+			extensions: []string{},
+			want:       true,
+		},
+		{
+			name:       "nil extensions match any file",
+			target:     "README",
+			extensions: nil,
+			want:       true,
+		},
+		{
+			name:       "matching extension",
+			target:     "stacks/docker-compose.yml",
+			extensions: []string{".yml", ".yaml"},
+			want:       true,
+		},
+		{
+			name:       "not matching extension",
+			target:     "stacks/README.md",
+			extensions: []string{".yml", ".yaml"},
+			want:       false,
+		},
+		{
+			name:       "uppercase file name matches lowercase extension",
+			target:     "ci/Pipeline.YAML",
+			extensions: []string{".yml", ".yaml"},
+			want:       true,
+		},
+		{
+			name:       "mixed case extension matches lowercase file name",
+			target:     "ci/pipeline.yaml",
+			extensions: []string{".YaMl"},
+			want:       true,
+		},
+		{
+			name:          "uppercase file name doesn't match in case sensitive mode",
+			target:        "ci/Pipeline.YAML",
+			extensions:    []string{".yml", ".yaml"},
+			caseSensitive: true,
+			want:          false,
+		},
+		{
+			name:          "exact case matches in case sensitive mode",
+			target:        "ci/Pipeline.YAML",
+			extensions:    []string{".YAML"},
+			caseSensitive: true,
+			want:          true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, matchExtensions(tt.target, tt.extensions, tt.caseSensitive))
+		})
+	}
+}
+
+func Test_matchPatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		patterns []string
+		want     bool
+	}{
+		{name: "empty patterns match any file", target: "README.md", want: true},
+		{name: "exact file name", target: "docker-compose.yml", patterns: []string{"docker-compose.yml"}, want: true},
+		{name: "root pattern doesn't match nested file", target: "stacks/docker-compose.yml", patterns: []string{"docker-compose.yml"}, want: false},
+		{name: "wildcard file name", target: "docker-compose.prod.yml", patterns: []string{"docker-compose*.y*ml"}, want: true},
+		{name: "wildcard file name with yaml extension", target: "docker-compose.yaml", patterns: []string{"docker-compose*.y*ml"}, want: true},
+		{name: "double star matches root file", target: "docker-compose.yml", patterns: []string{"**/docker-compose*.y*ml"}, want: true},
+		{name: "double star matches nested file", target: "a/b/c/docker-compose.dev.yml", patterns: []string{"**/docker-compose*.y*ml"}, want: true},
+		{name: "double star doesn't match other file", target: "a/b/c/compose.yml", patterns: []string{"**/docker-compose*.y*ml"}, want: false},
+		{name: "directory scoped", target: "stacks/web/docker-compose.yml", patterns: []string{"stacks/**"}, want: true},
+		{name: "directory scoped with extension", target: "stacks/web/docker-compose.yml", patterns: []string{"stacks/**/*.yml"}, want: true},
+		{name: "outside of scoped directory", target: "other/docker-compose.yml", patterns: []string{"stacks/**"}, want: false},
+		{name: "single star doesn't cross folders", target: "stacks/web/docker-compose.yml", patterns: []string{"stacks/*.yml"}, want: false},
+		{name: "leading slash is ignored", target: "/stacks/docker-compose.yml", patterns: []string{"/stacks/*.yml"}, want: true},
+		{name: "any of the patterns", target: "k8s/deployment.yaml", patterns: []string{"stacks/**", "k8s/*.yaml"}, want: true},
+		{name: "invalid pattern", target: "stacks/docker-compose.yml", patterns: []string{"stacks/[.yml"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, matchPatterns(tt.target, tt.patterns))
+		})
+	}
+}
+
+func Test_isHiddenPath(t *testing.T) {
+	tests := []struct {
+		target     string
+		wantHidden bool
+		wantGitDir bool
+	}{
+		{target: "docker-compose.yml"},
+		{target: "stacks/web/docker-compose.yml"},
+		{target: ".env", wantHidden: true},
+		{target: ".github/workflows/ci.yml", wantHidden: true},
+		{target: "stacks/.config/web.yml", wantHidden: true},
+		{target: ".git/config", wantHidden: true, wantGitDir: true},
+		{target: "vendor/module/.GIT/HEAD", wantHidden: true, wantGitDir: true},
+		{target: ".gitignore", wantHidden: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			assert.Equal(t, tt.wantHidden, isHiddenPath(tt.target))
+			assert.Equal(t, tt.wantGitDir, isGitDirPath(tt.target))
+		})
+	}
+}
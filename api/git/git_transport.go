@@ -0,0 +1,117 @@
+package git
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+// installRoutingMu serializes the installations of the routing transport
+var installRoutingMu sync.Mutex
+
+// routingTransport is the process-wide go-git https transport installed by NewService. go-git only picks
+// the transport of a clone from its process-wide registry, so the sessions authenticated with a clientAuth are
+// routed to the dedicated transport of the client which created them, and the other ones to the transport
+// installed before, leaving the go-git clones made outside of the services unaffected.
+type routingTransport struct {
+	fallback transport.Transport
+}
+
+// installRoutingTransport installs the routing transport as the go-git https transport, unless it already is
+func installRoutingTransport() {
+	installRoutingMu.Lock()
+	defer installRoutingMu.Unlock()
+
+	if _, ok := client.Protocols["https"].(*routingTransport); ok {
+		return
+	}
+
+	client.InstallProtocol("https", &routingTransport{fallback: client.Protocols["https"]})
+}
+
+func (t *routingTransport) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	if a, ok := auth.(*clientAuth); ok {
+		return a.transport.NewUploadPackSession(ep, a.authMethod())
+	}
+
+	return t.fallbackTransport().NewUploadPackSession(ep, auth)
+}
+
+func (t *routingTransport) NewReceivePackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.ReceivePackSession, error) {
+	if a, ok := auth.(*clientAuth); ok {
+		return a.transport.NewReceivePackSession(ep, a.authMethod())
+	}
+
+	return t.fallbackTransport().NewReceivePackSession(ep, auth)
+}
+
+func (t *routingTransport) fallbackTransport() transport.Transport {
+	if t.fallback == nil {
+		return githttp.DefaultClient
+	}
+
+	return t.fallback
+}
+
+// clientAuth carries the credentials of a go-git session along with the dedicated transport of the client,
+// so that the routing transport can hand the session to it. Once routed, only the credentials are used.
+type clientAuth struct {
+	auth      *githttp.BasicAuth
+	transport transport.Transport
+}
+
+// authMethod returns the credentials of the session, nil when there are none so that go-git
+// falls back to the credentials of the repository URL
+func (a *clientAuth) authMethod() transport.AuthMethod {
+	if a.auth == nil {
+		return nil
+	}
+
+	return a.auth
+}
+
+func (a *clientAuth) Name() string {
+	return "http-client-auth"
+}
+
+func (a *clientAuth) String() string {
+	return a.Name()
+}
+
+// SetAuth applies the credentials when the session reaches another go-git https transport than the routing one
+func (a *clientAuth) SetAuth(r *http.Request) {
+	a.auth.SetAuth(r)
+}
+
+// newDedicatedHTTPClient returns a copy of base, with its own clone of the base transport, which verifies the TLS
+// certificates against the system pool extended with caBundle when set, and skips the verification when insecure
+func newDedicatedHTTPClient(base *http.Client, insecure bool, caBundle []byte) *http.Client {
+	var httpTransport *http.Transport
+	if baseTransport, ok := base.Transport.(*http.Transport); ok {
+		httpTransport = baseTransport.Clone()
+	} else {
+		httpTransport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+
+	switch {
+	case insecure:
+		httpTransport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	case len(caBundle) != 0:
+		rootCAs, _ := x509.SystemCertPool()
+		if rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+		rootCAs.AppendCertsFromPEM(caBundle)
+		httpTransport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
+	}
+
+	dedicated := *base
+	dedicated.Transport = httpTransport
+
+	return &dedicated
+}